@@ -1,67 +1,153 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"os"
-
-	"blorbo/pkg/lexer"
-	"blorbo/pkg/parser"
 )
 
 const version = "0.1.0"
 
-func runScript(src string) error {
-	l := lexer.New(src)
-	tokens, err := l.Scan()
-	if err != nil {
-		return errors.New("error: lexer error(s) occurred")
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// errUsage is returned by a command given invalid arguments, once the
+// problem has been printed.
+var errUsage = errors.New("invalid arguments")
+
+// errFailed is returned by a command that has already reported why it
+// failed.
+var errFailed = errors.New("failed")
+
+type command struct {
+	name  string
+	args  string
+	short string
+	run   func(flags *flag.FlagSet, args []string) error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"run", "[-engine vm|interp] [-path dirs] script [arguments...]", "run a script", runCmd},
+		{"repl", "[-engine vm|interp] [-path dirs]", "start an interactive session", replCmd},
+		{"check", "[-path dirs] files...", "check files for errors without running them", checkCmd},
+		{"tokens", "[-comments] file", "print the tokens of a file", tokensCmd},
+		{"ast", "[-json] file", "print the syntax tree of a file", astCmd},
+		{"fmt", "[-w] [files...]", "format source files", fmtCmd},
+		{"vet", "[-json] [-check=false...] files...", "report likely mistakes in source files", vetCmd},
+		{"lsp", "", "start a language server on standard input and output", lspCmd},
+		{"version", "", "print the version", versionCmd},
+	}
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: blorbo <command> [arguments]")
+	fmt.Fprintln(w, "       blorbo [-engine vm|interp] [-path dirs] script [arguments...]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.short)
 	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'blorbo <command> -help' for the arguments of a command. Without a")
+	fmt.Fprintln(w, "command blorbo runs the script given, or starts a session if there is none.")
+}
 
-	for _, tok := range tokens {
-		fmt.Println(tok)
+func lookup(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
 	}
 
-	p := parser.New(tokens)
-	stmt, err := p.Parse()
-	if err != nil {
-		return err
+	return nil
+}
+
+// exec runs a command, returning the exit code of the process.
+func (cmd *command) exec(args []string) int {
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: blorbo %s %s\n", cmd.name, cmd.args)
+		flags.PrintDefaults()
 	}
 
-	tree, _ := json.MarshalIndent(stmt, "", "  ")
-	fmt.Println(string(tree))
+	err := cmd.run(flags, args)
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.Is(err, errUsage):
+		return exitUsage
+	case errors.Is(err, errFailed):
+		return exitError
+	}
+
+	fmt.Fprintln(os.Stderr, err)
+	return exitError
+}
+
+// parse parses the flags of a command, which prints the problem with any
+// invalid flag, and checks the number of arguments left is in [min, max].
+// A max of -1 allows any number.
+func parse(flags *flag.FlagSet, args []string, min, max int) error {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+
+	if flags.NArg() < min || (max >= 0 && flags.NArg() > max) {
+		flags.Usage()
+		return errUsage
+	}
 
 	return nil
 }
 
 func main() {
-	if len(os.Args) == 1 {
-		fmt.Printf("Blorbo %s\n", version)
+	args := os.Args[1:]
 
-		reader := bufio.NewReader(os.Stdin)
-		for {
-			fmt.Print("> ")
-			src, _ := reader.ReadString('\n')
+	if len(args) == 0 {
+		os.Exit(lookup("repl").exec(nil))
+	}
 
-			if err := runScript(src); err != nil {
-				fmt.Println(err)
-			}
-		}
-	} else if len(os.Args) == 2 {
-		src, err := os.ReadFile(os.Args[1])
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		usage(os.Stdout)
+		return
+	case "-version", "--version":
+		os.Exit(lookup("version").exec(nil))
+	}
 
-		if err := runScript(string(src)); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-	} else {
-		fmt.Println("usage: blorbo [script]")
-		os.Exit(1)
+	if cmd := lookup(args[0]); cmd != nil {
+		os.Exit(cmd.exec(args[1:]))
 	}
+
+	// Anything else is a script to run, possibly after the flags of run, or
+	// just those flags to start a session with
+	flags := flag.NewFlagSet("blorbo", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	sessionFlags(flags)
+	if flags.Parse(args) == nil && flags.NArg() == 0 {
+		os.Exit(lookup("repl").exec(args))
+	}
+
+	os.Exit(lookup("run").exec(args))
+}
+
+func versionCmd(flags *flag.FlagSet, args []string) error {
+	if err := parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	fmt.Printf("blorbo %s\n", version)
+	return nil
 }
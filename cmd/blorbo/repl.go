@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/diag"
+	"blorbo/pkg/lexer"
+	"blorbo/pkg/object"
+	"blorbo/pkg/parser"
+	"blorbo/pkg/resolver"
+	"blorbo/pkg/token"
+)
+
+// maxHistory is the number of inputs kept in the history file.
+const maxHistory = 1000
+
+// repl is an interactive session, which runs each input in the same engine
+// so that the globals it declares can be used by the inputs after it.
+type repl struct {
+	*session
+
+	env     engine
+	globals []*resolver.Decl
+
+	// input is the program of the input being run, which runs in env while
+	// the modules it imports run in engines of their own
+	input  *ast.Program
+	values map[string]object.Value
+
+	history     []string
+	historyFile string
+}
+
+type replCommand struct {
+	name  string
+	args  string
+	short string
+	run   func(r *repl, arg string) error
+}
+
+var replCommands []*replCommand
+
+func init() {
+	replCommands = []*replCommand{
+		{"help", "", "print this help", (*repl).help},
+		{"tokens", "code", "print the tokens of code", (*repl).showTokens},
+		{"ast", "code", "print the syntax tree of code", (*repl).showAST},
+		{"load", "file", "run a file in the session", (*repl).load},
+		{"reset", "", "forget every global declared in the session", (*repl).reset},
+		{"history", "", "print the inputs entered before", (*repl).printHistory},
+		{"quit", "", "end the session", nil},
+	}
+}
+
+// replCmd starts an interactive session, reading input from the standard
+// input until it ends or :quit is entered.
+func replCmd(flags *flag.FlagSet, args []string) error {
+	engine, path := sessionFlags(flags)
+	if err := parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	s, err := newSession(*engine, *path, nil)
+	if err != nil {
+		return err
+	}
+
+	r := &repl{session: s, env: s.newEngine(), historyFile: historyFile()}
+	r.loadHistory()
+
+	fmt.Printf("Blorbo %s\n", version)
+	fmt.Println("Type :help for help.")
+
+	return r.loop(bufio.NewReader(os.Stdin))
+}
+
+func (r *repl) loop(in *bufio.Reader) error {
+	var input strings.Builder
+	for {
+		if input.Len() == 0 {
+			fmt.Print("> ")
+		} else {
+			fmt.Print("... ")
+		}
+
+		line, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line == "" {
+			// The input ended, which is how a session is meant to end
+			fmt.Println()
+			return nil
+		}
+
+		if input.Len() == 0 {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" {
+				continue
+			}
+
+			if strings.HasPrefix(trimmed, ":") {
+				r.addHistory(trimmed)
+				if quit := r.command(trimmed[1:]); quit {
+					return nil
+				}
+				continue
+			}
+		}
+
+		input.WriteString(line)
+		if incomplete(input.String()) && err == nil {
+			continue
+		}
+
+		src := input.String()
+		input.Reset()
+
+		r.addHistory(strings.TrimRight(src, "\n"))
+		if err := r.eval(src, "", true); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// incomplete reports whether src has brackets left open, so that the input
+// continues on the next line.
+func incomplete(src string) bool {
+	tokens, _ := lexer.New(src).Scan()
+
+	depth := 0
+	for _, tok := range tokens {
+		switch tok.Type {
+		case token.LeftParen, token.LeftBrace, token.LeftBracket, token.StringHead:
+			depth++
+		case token.RightParen, token.RightBrace, token.RightBracket, token.StringTail:
+			depth--
+		}
+	}
+
+	return depth > 0
+}
+
+// command runs a meta-command, reporting whether it ends the session.
+func (r *repl) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	for _, cmd := range replCommands {
+		if cmd.name != name {
+			continue
+		}
+
+		if cmd.run == nil {
+			return true
+		}
+
+		if err := cmd.run(r, arg); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "unknown command ':%s', type :help for help\n", name)
+	return false
+}
+
+func (r *repl) help(arg string) error {
+	fmt.Println("Enter code to run it. The value of an expression is printed and kept in _.")
+	fmt.Println()
+	fmt.Println("Commands:")
+	for _, cmd := range replCommands {
+		fmt.Printf("  %-15s %s\n", strings.TrimSpace(":"+cmd.name+" "+cmd.args), cmd.short)
+	}
+	return nil
+}
+
+func (r *repl) showTokens(arg string) error {
+	tokens, err := lexer.New(arg).Scan()
+	printTokens(os.Stdout, tokens)
+	return err
+}
+
+func (r *repl) showAST(arg string) error {
+	program, err := parseSource(arg)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range program.Stmts {
+		dump(os.Stdout, stmt, 0)
+	}
+	return nil
+}
+
+func (r *repl) load(arg string) error {
+	if arg == "" {
+		return errors.New("usage: :load file")
+	}
+
+	src, err := os.ReadFile(arg)
+	if err != nil {
+		return err
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(arg))
+	if err != nil {
+		return err
+	}
+
+	err = r.eval(string(src), dir, false)
+	diag.SetFile(err, arg)
+	return err
+}
+
+func (r *repl) reset(arg string) error {
+	r.env = r.newEngine()
+	r.globals = nil
+	return nil
+}
+
+func (r *repl) printHistory(arg string) error {
+	for n, input := range r.history {
+		fmt.Printf("%5d  %s\n", n+1, strings.ReplaceAll(input, "\n", "\n       "))
+	}
+	return nil
+}
+
+// eval runs src, resolving its imports relative to dir or else the working
+// directory. If echo is set, the value of an expression ending the input is
+// printed.
+func (r *repl) eval(src string, dir string, echo bool) error {
+	program, err := parseSource(src)
+	if err != nil {
+		// A missing semicolon at the end of the input is forgiven
+		if fixed, fixErr := parseSource(src + ";"); fixErr == nil {
+			program, err = fixed, nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	result := echo && keepResult(program)
+
+	if dir == "" {
+		if dir, err = os.Getwd(); err != nil {
+			return err
+		}
+	}
+
+	m, err := r.loader.LoadProgram(program, dir, r.globals)
+	if err != nil {
+		return err
+	}
+
+	// Globals declared later replace those declared before with the same
+	// name, including the globals of earlier input
+	var globals []*resolver.Decl
+	seen := make(map[string]int)
+	for _, decl := range m.Resolved.Scopes[0].Decls {
+		if decl.Kind == resolver.Builtin {
+			continue
+		}
+
+		if n, ok := seen[decl.Name.Literal]; ok {
+			globals[n] = decl
+		} else {
+			seen[decl.Name.Literal] = len(globals)
+			globals = append(globals, decl)
+		}
+	}
+	r.globals = globals
+
+	r.input = program
+	if err := r.loader.Run(m, r.execute); err != nil {
+		return err
+	}
+
+	if value := r.values["_"]; result && value != nil {
+		if _, ok := value.(object.Null); !ok {
+			fmt.Println(object.Inspect(value))
+		}
+	}
+	return nil
+}
+
+func (r *repl) execute(program *ast.Program, importer object.Importer) (map[string]object.Value, error) {
+	if program != r.input {
+		return r.session.execute(program, importer)
+	}
+
+	values, err := r.env.run(program, importer)
+	r.values = values
+	return values, err
+}
+
+// keepResult replaces an expression statement ending program with a
+// declaration of _ holding its value, reporting whether it did. An
+// assignment is left alone, as its value is already kept.
+func keepResult(program *ast.Program) bool {
+	if len(program.Stmts) == 0 {
+		return false
+	}
+
+	last := len(program.Stmts) - 1
+	stmt, ok := program.Stmts[last].(*ast.ExprStmt)
+	if !ok {
+		return false
+	}
+
+	switch stmt.Value.(type) {
+	case *ast.AssignExpr, *ast.SetExpr, *ast.SetIndexExpr:
+		return false
+	}
+
+	name := token.Token{Type: token.Ident, Literal: "_", Pos: stmt.Pos(), End: stmt.Pos()}
+	program.Stmts[last] = &ast.VarStmt{Name: name, Value: stmt.Value, Span: stmt.Span}
+	return true
+}
+
+func parseSource(src string) (*ast.Program, error) {
+	tokens, lexErr := lexer.New(src).Scan()
+	program, err := parser.New(tokens).Parse()
+	if err := diag.Join(lexErr, err); err != nil {
+		return nil, err
+	}
+
+	return program, nil
+}
+
+// historyFile returns the file the history is kept in, which is named by
+// $BLORBO_HISTORY or else is .blorbo_history in the home directory.
+func historyFile() string {
+	if file := os.Getenv("BLORBO_HISTORY"); file != "" {
+		return file
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".blorbo_history")
+}
+
+// loadHistory reads the history of earlier sessions. The file holds an input
+// per line, quoted so that inputs can span lines.
+func (r *repl) loadHistory() {
+	if r.historyFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(r.historyFile)
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if input, err := strconv.Unquote(line); err == nil {
+			r.history = append(r.history, input)
+		}
+	}
+
+	// The file is only trimmed here, so it grows during a session
+	if len(r.history) > maxHistory {
+		r.history = r.history[len(r.history)-maxHistory:]
+
+		var buf strings.Builder
+		for _, input := range r.history {
+			fmt.Fprintln(&buf, strconv.Quote(input))
+		}
+		os.WriteFile(r.historyFile, []byte(buf.String()), 0o600)
+	}
+}
+
+// addHistory adds an input to the history, and to the history file if it
+// can be written.
+func (r *repl) addHistory(input string) {
+	r.history = append(r.history, input)
+	if r.historyFile == "" {
+		return
+	}
+
+	file, err := os.OpenFile(r.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	fmt.Fprintln(file, strconv.Quote(input))
+}
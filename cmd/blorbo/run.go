@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/compiler"
+	"blorbo/pkg/interp"
+	"blorbo/pkg/module"
+	"blorbo/pkg/object"
+	"blorbo/pkg/vm"
+)
+
+// session holds what is needed to run code: the engine, the loader for the
+// modules it imports and the arguments given to the script.
+type session struct {
+	engine string
+	loader *module.Loader
+	args   []string
+}
+
+// sessionFlags adds the flags of the commands that run code to flags.
+func sessionFlags(flags *flag.FlagSet) (engine *string, path *string) {
+	engine = flags.String("engine", "vm", "execution engine to use (vm or interp)")
+	path = flags.String("path", os.Getenv("BLORBO_PATH"), "list of directories to search for imports")
+	return engine, path
+}
+
+func newSession(engine string, path string, args []string) (*session, error) {
+	if engine != "vm" && engine != "interp" {
+		return nil, fmt.Errorf("unknown engine '%s'", engine)
+	}
+
+	return &session{
+		engine: engine,
+		loader: module.NewLoader(filepath.SplitList(path)),
+		args:   args,
+	}, nil
+}
+
+func (s *session) runFile(file string) error {
+	m, err := s.loader.Load(file)
+	if err != nil {
+		return err
+	}
+
+	return s.loader.Run(m, s.execute)
+}
+
+func (s *session) execute(program *ast.Program, importer object.Importer) (map[string]object.Value, error) {
+	return s.newEngine().run(program, importer)
+}
+
+// engine runs programs, keeping the globals they define from one run to the
+// next.
+type engine interface {
+	run(program *ast.Program, importer object.Importer) (map[string]object.Value, error)
+}
+
+type vmEngine struct {
+	vm *vm.VM
+}
+
+func (e *vmEngine) run(program *ast.Program, importer object.Importer) (map[string]object.Value, error) {
+	fn, err := compiler.New().Compile(program)
+	if err != nil {
+		return nil, err
+	}
+
+	e.vm.SetImporter(importer)
+	if err := e.vm.Run(fn); err != nil {
+		return nil, err
+	}
+
+	return e.vm.Globals(), nil
+}
+
+type interpEngine struct {
+	interp *interp.Interpreter
+}
+
+func (e *interpEngine) run(program *ast.Program, importer object.Importer) (map[string]object.Value, error) {
+	e.interp.SetImporter(importer)
+	if err := e.interp.Run(program); err != nil {
+		return nil, err
+	}
+
+	return e.interp.Globals(), nil
+}
+
+func (s *session) newEngine() engine {
+	switch s.engine {
+	case "vm":
+		machine := vm.New(os.Stdout)
+		machine.SetArgs(s.args)
+		return &vmEngine{machine}
+	default:
+		interpreter := interp.New(os.Stdout)
+		interpreter.SetArgs(s.args)
+		return &interpEngine{interpreter}
+	}
+}
+
+// runCmd runs a script, passing it the arguments after its name.
+func runCmd(flags *flag.FlagSet, args []string) error {
+	engine, path := sessionFlags(flags)
+	if err := parse(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	s, err := newSession(*engine, *path, flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+
+	return s.runFile(flags.Arg(0))
+}
+
+// checkCmd loads files and the modules they import, reporting any errors
+// found before running them.
+func checkCmd(flags *flag.FlagSet, args []string) error {
+	path := flags.String("path", os.Getenv("BLORBO_PATH"), "list of directories to search for imports")
+	if err := parse(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	loader := module.NewLoader(filepath.SplitList(*path))
+
+	var failed error
+	for _, file := range flags.Args() {
+		if _, err := loader.Load(file); err != nil {
+			var pathErr *os.PathError
+			if errors.As(err, &pathErr) {
+				err = fmt.Errorf("cannot read %s: %w", file, pathErr.Err)
+			}
+
+			fmt.Fprintln(os.Stderr, err)
+			failed = errFailed
+		}
+	}
+
+	return failed
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"blorbo/pkg/analysis"
+	"blorbo/pkg/diag"
+	"blorbo/pkg/format"
+	"blorbo/pkg/lexer"
+	"blorbo/pkg/lsp"
+	"blorbo/pkg/parser"
+	"blorbo/pkg/resolver"
+	"blorbo/pkg/token"
+)
+
+// report prints the diagnostics in err, naming file, to standard error.
+func report(err error, file string) error {
+	diag.SetFile(err, file)
+	fmt.Fprintln(os.Stderr, err)
+	return errFailed
+}
+
+// tokensCmd prints the tokens of a file, one per line, followed by any
+// lexical errors.
+func tokensCmd(flags *flag.FlagSet, args []string) error {
+	comments := flags.Bool("comments", false, "include comments")
+	if err := parse(flags, args, 1, 1); err != nil {
+		return err
+	}
+
+	file := flags.Arg(0)
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	l := lexer.New(string(src))
+	if *comments {
+		l.KeepComments()
+	}
+
+	tokens, err := l.Scan()
+	printTokens(os.Stdout, tokens)
+	if err != nil {
+		return report(err, file)
+	}
+	return nil
+}
+
+func printTokens(w io.Writer, tokens []token.Token) {
+	for _, tok := range tokens {
+		fmt.Fprintf(w, "%d:%d\t%s\t%q\n", tok.Line, tok.Column, tok.Type, tok.Literal)
+	}
+}
+
+// astCmd prints the syntax tree of a file, or its syntax errors.
+func astCmd(flags *flag.FlagSet, args []string) error {
+	asJSON := flags.Bool("json", false, "print the tree as JSON")
+	if err := parse(flags, args, 1, 1); err != nil {
+		return err
+	}
+
+	file := flags.Arg(0)
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	tokens, lexErr := lexer.New(string(src)).Scan()
+	program, parseErr := parser.New(tokens).Parse()
+	if err := diag.Join(lexErr, parseErr); err != nil {
+		return report(err, file)
+	}
+
+	if *asJSON {
+		out, err := json.MarshalIndent(program, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(out))
+		return nil
+	}
+
+	for _, stmt := range program.Stmts {
+		dump(os.Stdout, stmt, 0)
+	}
+	return nil
+}
+
+var (
+	tokenType = reflect.TypeOf(token.Token{})
+	spanType  = reflect.TypeOf(token.Span{})
+)
+
+// dump prints a node and the nodes within it, one field per line, indented
+// by depth.
+func dump(w io.Writer, node interface{}, depth int) {
+	v := reflect.ValueOf(node)
+	if !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+		fmt.Fprintln(w, "nil")
+		return
+	}
+
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	fmt.Fprintln(w, v.Type().Name())
+
+	indent := strings.Repeat("  ", depth+1)
+	for n := 0; n < v.NumField(); n++ {
+		field, value := v.Type().Field(n), v.Field(n)
+		if field.Type == spanType {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s%s: ", indent, field.Name)
+		switch {
+		case field.Type == tokenType:
+			fmt.Fprintf(w, "%q\n", value.Interface().(token.Token).Literal)
+		case value.Kind() == reflect.Slice:
+			if value.Len() == 0 {
+				fmt.Fprintln(w, "[]")
+				continue
+			}
+
+			fmt.Fprintln(w)
+			for i := 0; i < value.Len(); i++ {
+				fmt.Fprintf(w, "%s  - ", indent)
+				element := value.Index(i)
+				if element.Type() == tokenType {
+					fmt.Fprintf(w, "%q\n", element.Interface().(token.Token).Literal)
+				} else {
+					dump(w, element.Interface(), depth+2)
+				}
+			}
+		default:
+			dump(w, value.Interface(), depth+1)
+		}
+	}
+}
+
+// fmtCmd formats the files named in args, or the standard input if there
+// are none, and prints the result unless -w is given.
+func fmtCmd(flags *flag.FlagSet, args []string) error {
+	write := flags.Bool("w", false, "write the result to the files instead of printing it")
+	if err := parse(flags, args, 0, -1); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+
+		out, err := format.Source(string(src))
+		if err != nil {
+			return report(err, "")
+		}
+
+		fmt.Print(out)
+		return nil
+	}
+
+	var failed error
+	for _, file := range flags.Args() {
+		if err := fmtFile(file, *write); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = errFailed
+		}
+	}
+
+	return failed
+}
+
+func fmtFile(file string, write bool) error {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	out, err := format.Source(string(src))
+	if err != nil {
+		diag.SetFile(err, file)
+		return err
+	}
+
+	if !write {
+		fmt.Print(out)
+		return nil
+	}
+
+	if out == string(src) {
+		return nil
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, []byte(out), info.Mode().Perm())
+}
+
+// vetCmd runs the analyzers enabled in args over the files named there.
+// Each analyzer has a flag of its own name to turn it off.
+func vetCmd(flags *flag.FlagSet, args []string) error {
+	asJSON := flags.Bool("json", false, "print the findings as a JSON array")
+	enabled := make(map[*analysis.Analyzer]*bool)
+	for _, analyzer := range analysis.Analyzers {
+		enabled[analyzer] = flags.Bool(analyzer.Name, true, analyzer.Doc)
+	}
+	if err := parse(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	var analyzers []*analysis.Analyzer
+	for _, analyzer := range analysis.Analyzers {
+		if *enabled[analyzer] {
+			analyzers = append(analyzers, analyzer)
+		}
+	}
+
+	findings := diag.List{}
+	for _, file := range flags.Args() {
+		diags, err := vetFile(file, analyzers)
+		if err != nil {
+			var list diag.List
+			if !errors.As(err, &list) {
+				return err
+			}
+			diags = list
+		}
+
+		findings = append(findings, diags...)
+	}
+
+	if *asJSON {
+		out, err := json.MarshalIndent(findings, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	} else {
+		for _, d := range findings {
+			fmt.Println(d)
+		}
+	}
+
+	if len(findings) > 0 {
+		return errFailed
+	}
+	return nil
+}
+
+// vetFile returns the findings of analyzers in a file, or its errors if it
+// does not parse and resolve.
+func vetFile(file string, analyzers []*analysis.Analyzer) (diag.List, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, lexErr := lexer.New(string(src)).Scan()
+	program, parseErr := parser.New(tokens).Parse()
+
+	err = diag.Join(lexErr, parseErr)
+	var resolved *resolver.Result
+	if err == nil {
+		resolved, err = resolver.New().Resolve(program)
+	}
+	if err != nil {
+		diag.SetFile(err, file)
+		return nil, err
+	}
+
+	findings := analysis.Run(program, resolved, analyzers)
+	diag.SetFile(findings, file)
+	return findings, nil
+}
+
+func lspCmd(flags *flag.FlagSet, args []string) error {
+	if err := parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	return lsp.NewServer(os.Stdin, os.Stdout, version).Run()
+}
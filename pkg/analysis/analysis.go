@@ -0,0 +1,74 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/diag"
+	"blorbo/pkg/resolver"
+	"blorbo/pkg/token"
+)
+
+// Analyzer is a check for code that is valid but likely to be a mistake.
+type Analyzer struct {
+	// Name identifies the check, and is the code of the diagnostics it
+	// reports
+	Name string
+	Doc  string
+	Run  func(pass *Pass)
+}
+
+// Analyzers holds every check, in the order they run.
+var Analyzers = []*Analyzer{
+	Unused,
+	Unreachable,
+	AssignCond,
+	SelfAssign,
+	Shadow,
+	ArgCount,
+}
+
+// Pass is a program given to an analyzer to check, with the names in it
+// resolved.
+type Pass struct {
+	Program  *ast.Program
+	Resolved *resolver.Result
+
+	analyzer *Analyzer
+	diags    *diag.List
+}
+
+// Reportf reports a finding as a warning.
+func (p *Pass) Reportf(span token.Span, format string, args ...interface{}) {
+	*p.diags = append(*p.diags, &diag.Diagnostic{
+		Severity: diag.Warning,
+		Code:     diag.Code(p.analyzer.Name),
+		Message:  fmt.Sprintf(format, args...),
+		Span:     span,
+	})
+}
+
+// Run runs analyzers over a resolved program, returning their findings in
+// order of position.
+func Run(program *ast.Program, resolved *resolver.Result, analyzers []*Analyzer) diag.List {
+	var diags diag.List
+	for _, analyzer := range analyzers {
+		analyzer.Run(&Pass{
+			Program:  program,
+			Resolved: resolved,
+			analyzer: analyzer,
+			diags:    &diags,
+		})
+	}
+
+	sort.SliceStable(diags, func(i, j int) bool {
+		return diags[i].Span.Start.Offset < diags[j].Span.Start.Offset
+	})
+
+	return diags
+}
+
+func span(node ast.Node) token.Span {
+	return token.Span{Start: node.Pos(), End: node.End()}
+}
@@ -0,0 +1,32 @@
+package analysis
+
+import (
+	"testing"
+
+	"blorbo/pkg/diag"
+	"blorbo/pkg/internal/golden"
+	"blorbo/pkg/lexer"
+	"blorbo/pkg/parser"
+	"blorbo/pkg/resolver"
+)
+
+// TestGolden runs every analyzer over each file in testdata and compares the
+// findings with the .golden file next to it.
+func TestGolden(t *testing.T) {
+	golden.Run(t, func(t *testing.T, file string, src string) string {
+		tokens, _ := lexer.New(src).Scan()
+		program, err := parser.New(tokens).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resolved, err := resolver.New().Resolve(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		findings := Run(program, resolved, Analyzers)
+		diag.SetFile(findings, file)
+		return golden.Errors(findings.Err())
+	})
+}
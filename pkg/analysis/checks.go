@@ -0,0 +1,327 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/resolver"
+	"blorbo/pkg/token"
+	"blorbo/pkg/types"
+)
+
+var Unused = &Analyzer{
+	Name: "unused",
+	Doc:  "report local variables and parameters that are never used",
+	Run:  runUnused,
+}
+
+func runUnused(pass *Pass) {
+	// Assigning to a variable is not a use of it, even with an operator such
+	// as += or ++ that reads it, unless the value of the assignment is used
+	discarded := make(map[ast.Expr]bool)
+	ast.Inspect(pass.Program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.ExprStmt:
+			discarded[node.Value] = true
+		case *ast.ForStmt:
+			discarded[node.Inc] = true
+		}
+		return true
+	})
+
+	used := make(map[*resolver.Decl]bool)
+	for expr, binding := range pass.Resolved.Bindings {
+		if _, ok := expr.(*ast.AssignExpr); !ok || !discarded[expr] {
+			used[binding.Decl] = true
+		}
+	}
+
+	// Globals may be used by the modules importing them, and names starting
+	// with "_" are unused on purpose
+	for _, scope := range pass.Resolved.Scopes[1:] {
+		for _, decl := range scope.Decls {
+			if used[decl] || strings.HasPrefix(decl.Name.Literal, "_") {
+				continue
+			}
+
+			switch decl.Kind {
+			case resolver.Var:
+				pass.Reportf(decl.Name.Span(), "variable '%s' is declared but never used", decl.Name.Literal)
+			case resolver.Param:
+				pass.Reportf(decl.Name.Span(), "parameter '%s' is never used", decl.Name.Literal)
+			}
+		}
+	}
+}
+
+var Unreachable = &Analyzer{
+	Name: "unreachable",
+	Doc:  "report statements that can never run because they follow a return, break or continue",
+	Run:  runUnreachable,
+}
+
+func runUnreachable(pass *Pass) {
+	check := func(stmts []ast.Stmt) {
+		for n, stmt := range stmts {
+			if !terminates(stmt) {
+				continue
+			}
+
+			// Only the first unreachable statement is reported
+			for _, next := range stmts[n+1:] {
+				if next != nil {
+					pass.Reportf(span(next), "unreachable code")
+					return
+				}
+			}
+		}
+	}
+
+	check(pass.Program.Stmts)
+	ast.Inspect(pass.Program, func(node ast.Node) bool {
+		if block, ok := node.(*ast.BlockStmt); ok {
+			check(block.Body)
+		}
+		return true
+	})
+}
+
+// terminates reports whether a statement always returns, breaks or
+// continues, so that the statements after it never run.
+func terminates(stmt ast.Stmt) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStmt, *ast.BreakStmt, *ast.ContinueStmt:
+		return true
+	case *ast.BlockStmt:
+		for _, stmt := range stmt.Body {
+			if terminates(stmt) {
+				return true
+			}
+		}
+	case *ast.IfStmt:
+		return stmt.Else != nil && terminates(stmt.If) && terminates(stmt.Else)
+	}
+
+	return false
+}
+
+var AssignCond = &Analyzer{
+	Name: "assigncond",
+	Doc:  "report assignments used as the condition of an if, while or for statement",
+	Run:  runAssignCond,
+}
+
+func runAssignCond(pass *Pass) {
+	ast.Inspect(pass.Program, func(node ast.Node) bool {
+		var cond ast.Expr
+		switch node := node.(type) {
+		case *ast.IfStmt:
+			cond = node.Cond
+		case *ast.WhileStmt:
+			cond = node.Cond
+		case *ast.ForStmt:
+			cond = node.Cond
+		}
+
+		// Only "=" is likely to be a mistyped "=="
+		if op, ok := assignOp(cond); ok && op.Type == token.Assign {
+			pass.Reportf(span(cond), "assignment used as condition; did you mean '=='?")
+		}
+		return true
+	})
+}
+
+var SelfAssign = &Analyzer{
+	Name: "selfassign",
+	Doc:  "report assignments of a variable, field or element to itself",
+	Run:  runSelfAssign,
+}
+
+func runSelfAssign(pass *Pass) {
+	same := func(a, b ast.Expr) bool { return sameExpr(pass.Resolved, a, b) }
+
+	ast.Inspect(pass.Program, func(node ast.Node) bool {
+		// x += x and the like change x
+		if op, ok := assignOp(node); !ok || op.Type != token.Assign {
+			return true
+		}
+
+		switch node := node.(type) {
+		case *ast.AssignExpr:
+			value, ok := node.Value.(*ast.IdentExpr)
+			target, bound := pass.Resolved.Bindings[node]
+			if ok && bound && binding(pass.Resolved, value) == target.Decl {
+				pass.Reportf(node.Span, "self-assignment of '%s'", node.Name.Literal)
+			}
+		case *ast.SetExpr:
+			value, ok := node.Value.(*ast.GetExpr)
+			if ok && value.Name.Literal == node.Name.Literal && same(node.Object, value.Object) {
+				pass.Reportf(node.Span, "self-assignment of field '%s'", node.Name.Literal)
+			}
+		case *ast.SetIndexExpr:
+			value, ok := node.Value.(*ast.IndexExpr)
+			if ok && same(node.Object, value.Object) && same(node.Index, value.Index) {
+				pass.Reportf(node.Span, "self-assignment of element")
+			}
+		}
+		return true
+	})
+}
+
+// assignOp returns the operator of an assignment, and false if node is not
+// one.
+func assignOp(node ast.Node) (token.Token, bool) {
+	switch node := node.(type) {
+	case *ast.AssignExpr:
+		return node.Op, true
+	case *ast.SetExpr:
+		return node.Op, true
+	case *ast.SetIndexExpr:
+		return node.Op, true
+	}
+
+	return token.Token{}, false
+}
+
+func binding(resolved *resolver.Result, ident *ast.IdentExpr) *resolver.Decl {
+	if binding, ok := resolved.Bindings[ident]; ok {
+		return binding.Decl
+	}
+
+	return nil
+}
+
+// sameExpr reports whether two expressions without side effects always have
+// the same value.
+func sameExpr(resolved *resolver.Result, a, b ast.Expr) bool {
+	switch a := a.(type) {
+	case *ast.IdentExpr:
+		b, ok := b.(*ast.IdentExpr)
+		return ok && a.Name.Literal == b.Name.Literal && binding(resolved, a) != nil &&
+			binding(resolved, a) == binding(resolved, b)
+	case *ast.LiteralExpr:
+		b, ok := b.(*ast.LiteralExpr)
+		return ok && a.Value.Type == b.Value.Type && a.Value.Literal == b.Value.Literal
+	case *ast.GetExpr:
+		b, ok := b.(*ast.GetExpr)
+		return ok && a.Name.Literal == b.Name.Literal && sameExpr(resolved, a.Object, b.Object)
+	case *ast.IndexExpr:
+		b, ok := b.(*ast.IndexExpr)
+		return ok && sameExpr(resolved, a.Object, b.Object) && sameExpr(resolved, a.Index, b.Index)
+	}
+
+	return false
+}
+
+var Shadow = &Analyzer{
+	Name: "shadow",
+	Doc:  "report declarations that hide a declaration of the same name in an enclosing scope",
+	Run:  runShadow,
+}
+
+func runShadow(pass *Pass) {
+	scopes := pass.Resolved.Scopes
+
+	for n, scope := range scopes {
+		for _, decl := range scope.Decls {
+			if outer := enclosing(scopes[:n], decl); outer != nil {
+				pass.Reportf(
+					decl.Name.Span(), "declaration of '%s' shadows declaration on line %d",
+					decl.Name.Literal, outer.Name.Line,
+				)
+			}
+		}
+	}
+}
+
+// enclosing returns the declaration of the same name as decl visible where
+// decl is declared, searching scopes from the innermost. The scopes before
+// the scope of decl that contain it are the ones enclosing it.
+func enclosing(scopes []*resolver.Scope, decl *resolver.Decl) *resolver.Decl {
+	for n := len(scopes) - 1; n >= 0; n-- {
+		if !scopes[n].Contains(decl.Name.Offset) {
+			continue
+		}
+
+		for _, outer := range scopes[n].Decls {
+			// Builtins are left out, as shadowing them is not confusing
+			if outer.Name.Literal != decl.Name.Literal || outer.Kind == resolver.Builtin {
+				continue
+			}
+
+			// Locals are only visible after their declaration
+			if n > 0 && outer.Name.Offset > decl.Name.Offset {
+				continue
+			}
+
+			return outer
+		}
+	}
+
+	return nil
+}
+
+var ArgCount = &Analyzer{
+	Name: "argcount",
+	Doc:  "report calls to functions with the wrong number of arguments",
+	Run:  runArgCount,
+}
+
+func runArgCount(pass *Pass) {
+	// A variable holding a function may be assigned another one
+	assigned := make(map[*resolver.Decl]bool)
+	for expr, binding := range pass.Resolved.Bindings {
+		if _, ok := expr.(*ast.AssignExpr); ok {
+			assigned[binding.Decl] = true
+		}
+	}
+
+	ast.Inspect(pass.Program, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		ident, ok := call.Name.(*ast.IdentExpr)
+		if !ok {
+			return true
+		}
+
+		decl := binding(pass.Resolved, ident)
+		if decl == nil {
+			return true
+		}
+
+		want := -1
+		switch decl.Kind {
+		case resolver.Builtin:
+			if sig := types.Builtin(decl.Name.Literal); sig != nil && !sig.Variadic {
+				want = len(sig.Params)
+			}
+		case resolver.Fn:
+			want = len(decl.Node.(*ast.FnStmt).Params)
+		case resolver.Var:
+			fn, ok := decl.Node.(*ast.VarStmt).Value.(*ast.FnExpr)
+			if ok && !assigned[decl] {
+				want = len(fn.Params)
+			}
+		}
+
+		if want >= 0 && want != len(call.Args) {
+			pass.Reportf(
+				call.Span, "'%s' expects %s but got %d",
+				ident.Name.Literal, plural(want, "argument"), len(call.Args),
+			)
+		}
+		return true
+	})
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+
+	return fmt.Sprintf("%d %ss", n, noun)
+}
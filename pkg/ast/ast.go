@@ -4,82 +4,387 @@ import (
 	"blorbo/pkg/token"
 )
 
+// Node is a node of the syntax tree. Every node holds the Span of source
+// code it was parsed from, which Pos and End return the ends of.
+type Node interface {
+	Pos() token.Pos
+	End() token.Pos
+	node()
+}
+
 type Stmt interface {
+	Node
+	stmtNode()
 }
 
 type BlockStmt struct {
 	Body []Stmt
+	Span token.Span
 }
 
 type IfStmt struct {
 	Cond Expr
 	If   Stmt
 	Else Stmt
+	Span token.Span
 }
 
+// WhileStmt and ForStmt have a Label with an empty Literal unless they are
+// labeled.
 type WhileStmt struct {
-	Cond Expr
-	Body Stmt
+	Label token.Token
+	Cond  Expr
+	Body  Stmt
+	Span  token.Span
 }
 
 type ForStmt struct {
-	Init Stmt
-	Cond Expr
-	Inc  Expr
-	Body Stmt
+	Label token.Token
+	Init  Stmt
+	Cond  Expr
+	Inc   Expr
+	Body  Stmt
+	Span  token.Span
 }
 
-type FnStmt struct {
+type StructStmt struct {
 	Name   token.Token
-	Params []token.Token
-	Body   Stmt
+	Fields []token.Token
+	Span   token.Span
+}
+
+// FnStmt holds the type annotation of each parameter in ParamTypes, which
+// is nil for parameters without one. Result is nil when the return type is
+// not annotated.
+type FnStmt struct {
+	Name       token.Token
+	Params     []token.Token
+	ParamTypes []*TypeExpr
+	Result     *TypeExpr
+	Body       Stmt
+	Span       token.Span
 }
 
 type VarStmt struct {
 	Name  token.Token
+	Type  *TypeExpr
 	Value Expr
+	Span  token.Span
 }
 
 type ReturnStmt struct {
-	Value Expr
+	Keyword token.Token
+	Value   Expr
+	Span    token.Span
+}
+
+// ImportStmt binds the module at Path to Name, which is the base name of
+// the path without its extension.
+type ImportStmt struct {
+	Keyword token.Token
+	Path    token.Token
+	Name    token.Token
+	Span    token.Span
+}
+
+// BreakStmt and ContinueStmt have a Label with an empty Literal unless they
+// name the loop they apply to.
+type BreakStmt struct {
+	Keyword token.Token
+	Label   token.Token
+	Span    token.Span
+}
+
+type ContinueStmt struct {
+	Keyword token.Token
+	Label   token.Token
+	Span    token.Span
+}
+
+// BadStmt is a placeholder for a statement that could not be parsed.
+type BadStmt struct {
+	Span token.Span
 }
 
 type ExprStmt struct {
 	Value Expr
+	Span  token.Span
 }
 
 type Expr interface {
+	Node
+	exprNode()
 }
 
+// AssignExpr assigns to a variable. Op is "=" or a compound operator such as
+// "+=", which stores the result of its binary operator on the variable and
+// Value. x++ and x-- are parsed as x += 1 and x -= 1 with "++" or "--" as Op.
 type AssignExpr struct {
 	Name  token.Token
+	Op    token.Token
 	Value Expr
+	Span  token.Span
 }
 
 type BinaryExpr struct {
 	Left  Expr
 	Op    token.Token
 	Right Expr
+	Span  token.Span
 }
 
 type UnaryExpr struct {
 	Op    token.Token
 	Right Expr
+	Span  token.Span
 }
 
 type CallExpr struct {
-	Name Expr
-	Args []Expr
+	Name  Expr
+	Paren token.Token
+	Args  []Expr
+	Span  token.Span
+}
+
+type GetExpr struct {
+	Object Expr
+	Name   token.Token
+	Span   token.Span
+}
+
+// SetExpr assigns to a field, with Op as in AssignExpr.
+type SetExpr struct {
+	Object Expr
+	Name   token.Token
+	Op     token.Token
+	Value  Expr
+	Span   token.Span
+}
+
+type IndexExpr struct {
+	Object  Expr
+	Bracket token.Token
+	Index   Expr
+	Span    token.Span
+}
+
+// SetIndexExpr assigns to an element, with Op as in AssignExpr.
+type SetIndexExpr struct {
+	Object  Expr
+	Bracket token.Token
+	Index   Expr
+	Op      token.Token
+	Value   Expr
+	Span    token.Span
+}
+
+type ArrayLiteral struct {
+	Bracket  token.Token
+	Elements []Expr
+	Span     token.Span
+}
+
+type FnExpr struct {
+	Keyword    token.Token
+	Params     []token.Token
+	ParamTypes []*TypeExpr
+	Result     *TypeExpr
+	Body       Stmt
+	Span       token.Span
+}
+
+type MapLiteral struct {
+	Brace  token.Token
+	Keys   []Expr
+	Values []Expr
+	Span   token.Span
+}
+
+type StructLiteral struct {
+	Name   token.Token
+	Fields []token.Token
+	Values []Expr
+	Span   token.Span
+}
+
+// BadExpr is a placeholder for an expression that could not be parsed.
+type BadExpr struct {
+	Span token.Span
 }
 
 type IdentExpr struct {
 	Name token.Token
+	Span token.Span
 }
 
 type LiteralExpr struct {
 	Value token.Token
+	Span  token.Span
+}
+
+// InterpolatedString is a string with expressions in it, such as
+// "Hello, ${name}!". Literals holds the text around the expressions, with one
+// more element than Exprs: the first is the text before Exprs[0] and each
+// other the text after the expression before it.
+type InterpolatedString struct {
+	Literals []token.Token
+	Exprs    []Expr
+	Span     token.Span
+}
+
+// TypeExpr is a type annotation, naming a builtin type or a struct.
+type TypeExpr struct {
+	Name token.Token
+	Span token.Span
 }
 
 type Program struct {
 	Stmts []Stmt
 }
+
+// Pos and End of a program are those of its first and last statements.
+func (p *Program) Pos() token.Pos {
+	if len(p.Stmts) == 0 {
+		return token.Pos{}
+	}
+	return p.Stmts[0].Pos()
+}
+
+func (p *Program) End() token.Pos {
+	if len(p.Stmts) == 0 {
+		return token.Pos{}
+	}
+	return p.Stmts[len(p.Stmts)-1].End()
+}
+
+func (*Program) node() {}
+
+func (n *BlockStmt) Pos() token.Pos    { return n.Span.Start }
+func (n *IfStmt) Pos() token.Pos       { return n.Span.Start }
+func (n *WhileStmt) Pos() token.Pos    { return n.Span.Start }
+func (n *ForStmt) Pos() token.Pos      { return n.Span.Start }
+func (n *StructStmt) Pos() token.Pos   { return n.Span.Start }
+func (n *FnStmt) Pos() token.Pos       { return n.Span.Start }
+func (n *VarStmt) Pos() token.Pos      { return n.Span.Start }
+func (n *ReturnStmt) Pos() token.Pos   { return n.Span.Start }
+func (n *ImportStmt) Pos() token.Pos   { return n.Span.Start }
+func (n *BreakStmt) Pos() token.Pos    { return n.Span.Start }
+func (n *ContinueStmt) Pos() token.Pos { return n.Span.Start }
+func (n *BadStmt) Pos() token.Pos      { return n.Span.Start }
+func (n *ExprStmt) Pos() token.Pos     { return n.Span.Start }
+
+func (n *BlockStmt) End() token.Pos    { return n.Span.End }
+func (n *IfStmt) End() token.Pos       { return n.Span.End }
+func (n *WhileStmt) End() token.Pos    { return n.Span.End }
+func (n *ForStmt) End() token.Pos      { return n.Span.End }
+func (n *StructStmt) End() token.Pos   { return n.Span.End }
+func (n *FnStmt) End() token.Pos       { return n.Span.End }
+func (n *VarStmt) End() token.Pos      { return n.Span.End }
+func (n *ReturnStmt) End() token.Pos   { return n.Span.End }
+func (n *ImportStmt) End() token.Pos   { return n.Span.End }
+func (n *BreakStmt) End() token.Pos    { return n.Span.End }
+func (n *ContinueStmt) End() token.Pos { return n.Span.End }
+func (n *BadStmt) End() token.Pos      { return n.Span.End }
+func (n *ExprStmt) End() token.Pos     { return n.Span.End }
+
+func (*BlockStmt) node()    {}
+func (*IfStmt) node()       {}
+func (*WhileStmt) node()    {}
+func (*ForStmt) node()      {}
+func (*StructStmt) node()   {}
+func (*FnStmt) node()       {}
+func (*VarStmt) node()      {}
+func (*ReturnStmt) node()   {}
+func (*ImportStmt) node()   {}
+func (*BreakStmt) node()    {}
+func (*ContinueStmt) node() {}
+func (*BadStmt) node()      {}
+func (*ExprStmt) node()     {}
+
+func (*BlockStmt) stmtNode()    {}
+func (*IfStmt) stmtNode()       {}
+func (*WhileStmt) stmtNode()    {}
+func (*ForStmt) stmtNode()      {}
+func (*StructStmt) stmtNode()   {}
+func (*FnStmt) stmtNode()       {}
+func (*VarStmt) stmtNode()      {}
+func (*ReturnStmt) stmtNode()   {}
+func (*ImportStmt) stmtNode()   {}
+func (*BreakStmt) stmtNode()    {}
+func (*ContinueStmt) stmtNode() {}
+func (*BadStmt) stmtNode()      {}
+func (*ExprStmt) stmtNode()     {}
+
+func (n *AssignExpr) Pos() token.Pos         { return n.Span.Start }
+func (n *BinaryExpr) Pos() token.Pos         { return n.Span.Start }
+func (n *UnaryExpr) Pos() token.Pos          { return n.Span.Start }
+func (n *CallExpr) Pos() token.Pos           { return n.Span.Start }
+func (n *GetExpr) Pos() token.Pos            { return n.Span.Start }
+func (n *SetExpr) Pos() token.Pos            { return n.Span.Start }
+func (n *IndexExpr) Pos() token.Pos          { return n.Span.Start }
+func (n *SetIndexExpr) Pos() token.Pos       { return n.Span.Start }
+func (n *ArrayLiteral) Pos() token.Pos       { return n.Span.Start }
+func (n *FnExpr) Pos() token.Pos             { return n.Span.Start }
+func (n *MapLiteral) Pos() token.Pos         { return n.Span.Start }
+func (n *StructLiteral) Pos() token.Pos      { return n.Span.Start }
+func (n *BadExpr) Pos() token.Pos            { return n.Span.Start }
+func (n *IdentExpr) Pos() token.Pos          { return n.Span.Start }
+func (n *LiteralExpr) Pos() token.Pos        { return n.Span.Start }
+func (n *InterpolatedString) Pos() token.Pos { return n.Span.Start }
+
+func (n *AssignExpr) End() token.Pos         { return n.Span.End }
+func (n *BinaryExpr) End() token.Pos         { return n.Span.End }
+func (n *UnaryExpr) End() token.Pos          { return n.Span.End }
+func (n *CallExpr) End() token.Pos           { return n.Span.End }
+func (n *GetExpr) End() token.Pos            { return n.Span.End }
+func (n *SetExpr) End() token.Pos            { return n.Span.End }
+func (n *IndexExpr) End() token.Pos          { return n.Span.End }
+func (n *SetIndexExpr) End() token.Pos       { return n.Span.End }
+func (n *ArrayLiteral) End() token.Pos       { return n.Span.End }
+func (n *FnExpr) End() token.Pos             { return n.Span.End }
+func (n *MapLiteral) End() token.Pos         { return n.Span.End }
+func (n *StructLiteral) End() token.Pos      { return n.Span.End }
+func (n *BadExpr) End() token.Pos            { return n.Span.End }
+func (n *IdentExpr) End() token.Pos          { return n.Span.End }
+func (n *LiteralExpr) End() token.Pos        { return n.Span.End }
+func (n *InterpolatedString) End() token.Pos { return n.Span.End }
+
+func (*AssignExpr) node()         {}
+func (*BinaryExpr) node()         {}
+func (*UnaryExpr) node()          {}
+func (*CallExpr) node()           {}
+func (*GetExpr) node()            {}
+func (*SetExpr) node()            {}
+func (*IndexExpr) node()          {}
+func (*SetIndexExpr) node()       {}
+func (*ArrayLiteral) node()       {}
+func (*FnExpr) node()             {}
+func (*MapLiteral) node()         {}
+func (*StructLiteral) node()      {}
+func (*BadExpr) node()            {}
+func (*IdentExpr) node()          {}
+func (*LiteralExpr) node()        {}
+func (*InterpolatedString) node() {}
+
+func (*AssignExpr) exprNode()         {}
+func (*BinaryExpr) exprNode()         {}
+func (*UnaryExpr) exprNode()          {}
+func (*CallExpr) exprNode()           {}
+func (*GetExpr) exprNode()            {}
+func (*SetExpr) exprNode()            {}
+func (*IndexExpr) exprNode()          {}
+func (*SetIndexExpr) exprNode()       {}
+func (*ArrayLiteral) exprNode()       {}
+func (*FnExpr) exprNode()             {}
+func (*MapLiteral) exprNode()         {}
+func (*StructLiteral) exprNode()      {}
+func (*BadExpr) exprNode()            {}
+func (*IdentExpr) exprNode()          {}
+func (*LiteralExpr) exprNode()        {}
+func (*InterpolatedString) exprNode() {}
+
+func (n *TypeExpr) Pos() token.Pos { return n.Span.Start }
+
+func (n *TypeExpr) End() token.Pos { return n.Span.End }
+
+func (*TypeExpr) node() {}
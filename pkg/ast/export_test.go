@@ -0,0 +1,4 @@
+package ast
+
+// Kinds is the registry of node kinds, for the tests of package ast_test.
+var Kinds = kinds
@@ -0,0 +1,283 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"unicode"
+	"unicode/utf8"
+
+	"blorbo/pkg/token"
+)
+
+// A program is encoded as JSON with each node an object holding its kind,
+// the name of its type, followed by its fields in order, named in camel
+// case. Tokens, spans and positions are objects too, so the encoding keeps
+// every position in the tree and decodes to a program identical to the one
+// encoded. Nil nodes and lists are null, while empty lists are [].
+//
+//	{"kind": "ReturnStmt", "keyword": {...}, "value": null, "span": {...}}
+
+// kinds holds the type of every node, by the kind it is encoded with.
+var kinds = make(map[string]reflect.Type)
+
+func init() {
+	for _, node := range []interface{}{
+		Program{},
+		BlockStmt{}, IfStmt{}, WhileStmt{}, ForStmt{}, StructStmt{}, FnStmt{},
+		VarStmt{}, ReturnStmt{}, ImportStmt{}, BreakStmt{}, ContinueStmt{},
+		BadStmt{}, ExprStmt{},
+		AssignExpr{}, BinaryExpr{}, UnaryExpr{}, CallExpr{}, GetExpr{},
+		SetExpr{}, IndexExpr{}, SetIndexExpr{}, ArrayLiteral{}, FnExpr{},
+		MapLiteral{}, StructLiteral{}, BadExpr{}, IdentExpr{}, LiteralExpr{},
+		InterpolatedString{}, TypeExpr{},
+	} {
+		t := reflect.TypeOf(node)
+		kinds[t.Name()] = t
+	}
+}
+
+var (
+	tokenType = reflect.TypeOf(token.Token{})
+	spanType  = reflect.TypeOf(token.Span{})
+)
+
+type posJSON struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type spanJSON struct {
+	Start posJSON `json:"start"`
+	End   posJSON `json:"end"`
+}
+
+type tokenJSON struct {
+	Type    token.TokenType `json:"type"`
+	Literal string          `json:"literal"`
+	Pos     posJSON         `json:"pos"`
+	End     posJSON         `json:"end"`
+}
+
+func (p *Program) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, reflect.ValueOf(p)); err != nil {
+		return nil, fmt.Errorf("ast: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (p *Program) UnmarshalJSON(data []byte) error {
+	v, err := decode(data, reflect.TypeOf(p))
+	if err != nil {
+		return fmt.Errorf("ast: %w", err)
+	}
+
+	if v.IsNil() {
+		return fmt.Errorf("ast: program is null")
+	}
+
+	*p = *v.Interface().(*Program)
+	return nil
+}
+
+func encode(buf *bytes.Buffer, v reflect.Value) error {
+	switch {
+	case v.Type() == tokenType:
+		tok := v.Interface().(token.Token)
+		return encodeJSON(buf, tokenJSON{tok.Type, tok.Literal, toPosJSON(tok.Pos), toPosJSON(tok.End)})
+	case v.Type() == spanType:
+		span := v.Interface().(token.Span)
+		return encodeJSON(buf, spanJSON{toPosJSON(span.Start), toPosJSON(span.End)})
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+
+		return encodeNode(buf, v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+
+		buf.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encode(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+
+	return fmt.Errorf("cannot encode %s", v.Type())
+}
+
+func encodeNode(buf *bytes.Buffer, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			buf.WriteString("null")
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	t := v.Type()
+	if kinds[t.Name()] != t {
+		return fmt.Errorf("cannot encode %s", t)
+	}
+
+	fmt.Fprintf(buf, `{"kind":%q`, t.Name())
+	for n := 0; n < t.NumField(); n++ {
+		fmt.Fprintf(buf, ",%q:", fieldName(t.Field(n)))
+		if err := encode(buf, v.Field(n)); err != nil {
+			return err
+		}
+	}
+	buf.WriteByte('}')
+
+	return nil
+}
+
+func encodeJSON(buf *bytes.Buffer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	buf.Write(data)
+	return nil
+}
+
+// decode decodes data as a value of type t, which is a token, span, node,
+// list or one of the node interfaces.
+func decode(data []byte, t reflect.Type) (reflect.Value, error) {
+	switch t {
+	case tokenType:
+		var tok tokenJSON
+		if err := json.Unmarshal(data, &tok); err != nil {
+			return reflect.Value{}, err
+		}
+
+		return reflect.ValueOf(token.Token{
+			Type:    tok.Type,
+			Literal: tok.Literal,
+			Pos:     fromPosJSON(tok.Pos),
+			End:     fromPosJSON(tok.End),
+		}), nil
+	case spanType:
+		var span spanJSON
+		if err := json.Unmarshal(data, &span); err != nil {
+			return reflect.Value{}, err
+		}
+
+		return reflect.ValueOf(token.Span{Start: fromPosJSON(span.Start), End: fromPosJSON(span.End)}), nil
+	}
+
+	null := bytes.Equal(bytes.TrimSpace(data), []byte("null"))
+
+	switch t.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if null {
+			return reflect.Zero(t), nil
+		}
+
+		return decodeNode(data, t)
+	case reflect.Slice:
+		if null {
+			return reflect.Zero(t), nil
+		}
+
+		var elements []json.RawMessage
+		if err := json.Unmarshal(data, &elements); err != nil {
+			return reflect.Value{}, err
+		}
+
+		list := reflect.MakeSlice(t, len(elements), len(elements))
+		for i, element := range elements {
+			v, err := decode(element, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			list.Index(i).Set(v)
+		}
+
+		return list, nil
+	}
+
+	return reflect.Value{}, fmt.Errorf("cannot decode %s", t)
+}
+
+// decodeNode decodes a node of the kind given in data, which must be
+// assignable to t.
+func decodeNode(data []byte, t reflect.Type) (reflect.Value, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return reflect.Value{}, err
+	}
+
+	var kind string
+	if err := json.Unmarshal(fields["kind"], &kind); err != nil {
+		return reflect.Value{}, fmt.Errorf("node has no kind")
+	}
+
+	nodeType, ok := kinds[kind]
+	if !ok {
+		return reflect.Value{}, fmt.Errorf("unknown node kind '%s'", kind)
+	}
+
+	node := reflect.New(nodeType)
+	if !node.Type().AssignableTo(t) {
+		return reflect.Value{}, fmt.Errorf("%s is not a %s", kind, t.Name())
+	}
+
+	delete(fields, "kind")
+	for n := 0; n < nodeType.NumField(); n++ {
+		field := nodeType.Field(n)
+		name := fieldName(field)
+
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		delete(fields, name)
+
+		v, err := decode(raw, field.Type)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%s.%s: %w", kind, name, err)
+		}
+		node.Elem().Field(n).Set(v)
+	}
+
+	for name := range fields {
+		return reflect.Value{}, fmt.Errorf("%s has no field '%s'", kind, name)
+	}
+
+	return node, nil
+}
+
+// fieldName returns the name a field is encoded with, which is its name
+// starting in lower case.
+func fieldName(field reflect.StructField) string {
+	r, size := utf8.DecodeRuneInString(field.Name)
+	return string(unicode.ToLower(r)) + field.Name[size:]
+}
+
+func toPosJSON(pos token.Pos) posJSON {
+	return posJSON{pos.Offset, pos.Line, pos.Column}
+}
+
+func fromPosJSON(pos posJSON) token.Pos {
+	return token.Pos{Offset: pos.Offset, Line: pos.Line, Column: pos.Column}
+}
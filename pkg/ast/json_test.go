@@ -0,0 +1,70 @@
+package ast_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/lexer"
+	"blorbo/pkg/parser"
+)
+
+// corpus holds source covering every kind of node, including the Bad nodes
+// left by syntax errors.
+var corpus = []string{
+	`{ var a = 1; } if (a) b; else c;`,
+	`while (a) { break; continue; } outer: for (var i = 0; i < 3; i++) { break outer; }`,
+	`struct P { x, y } var p = P { x: 1, y: 2.5 }; p.x = p.y; p.x += 1;`,
+	`fn f(a: int, b): string { return "a${a}b${b}c"; } var g = fn (x: int) { return x; };`,
+	`import "lib/geometry.bb"; geometry.area(2, 3);`,
+	`var m = {"one": 1, 2: [3, -4, !true]}; m["one"] = null; m[2] -= m["one"];`,
+	`a = b = c; a--; a = 1 + 2 * 3 << 4 & 5 | 6 ^ 7 and x or y;`,
+	`var = ; x = 1 + ;`,
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	srcs := corpus
+	examples, _ := filepath.Glob("../../examples/*.bb")
+	for _, example := range examples {
+		src, err := os.ReadFile(example)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srcs = append(srcs, string(src))
+	}
+
+	seen := make(map[string]bool)
+	for _, src := range srcs {
+		tokens, _ := lexer.New(src).Scan()
+		program, _ := parser.New(tokens).Parse()
+		ast.Inspect(program, func(node ast.Node) bool {
+			if node != nil {
+				seen[reflect.TypeOf(node).Elem().Name()] = true
+			}
+			return true
+		})
+
+		data, err := json.Marshal(program)
+		if err != nil {
+			t.Fatalf("marshaling %q: %v", src, err)
+		}
+
+		var decoded ast.Program
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("unmarshaling %q: %v", src, err)
+		}
+
+		if !reflect.DeepEqual(program, &decoded) {
+			t.Errorf("%q does not round-trip, decoded to %s", src, data)
+		}
+	}
+
+	for kind := range ast.Kinds {
+		if !seen[kind] {
+			t.Errorf("no %s in the corpus", kind)
+		}
+	}
+}
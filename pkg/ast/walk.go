@@ -0,0 +1,324 @@
+package ast
+
+import "fmt"
+
+// Visitor is called by Walk for each node. If Visit returns a visitor w,
+// Walk visits the children of the node with w, followed by a call of
+// w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at node in depth-first order, calling
+// v.Visit for every node within it that is not nil.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		walkStmts(v, n.Stmts)
+	case *BlockStmt:
+		walkStmts(v, n.Body)
+	case *IfStmt:
+		walk(v, n.Cond)
+		walk(v, n.If)
+		walk(v, n.Else)
+	case *WhileStmt:
+		walk(v, n.Cond)
+		walk(v, n.Body)
+	case *ForStmt:
+		walk(v, n.Init)
+		walk(v, n.Cond)
+		walk(v, n.Inc)
+		walk(v, n.Body)
+	case *FnStmt:
+		walkTypes(v, n.ParamTypes)
+		walk(v, n.Result)
+		walk(v, n.Body)
+	case *VarStmt:
+		walk(v, n.Type)
+		walk(v, n.Value)
+	case *ReturnStmt:
+		walk(v, n.Value)
+	case *ExprStmt:
+		walk(v, n.Value)
+	case *AssignExpr:
+		walk(v, n.Value)
+	case *BinaryExpr:
+		walk(v, n.Left)
+		walk(v, n.Right)
+	case *UnaryExpr:
+		walk(v, n.Right)
+	case *CallExpr:
+		walk(v, n.Name)
+		walkExprs(v, n.Args)
+	case *GetExpr:
+		walk(v, n.Object)
+	case *SetExpr:
+		walk(v, n.Object)
+		walk(v, n.Value)
+	case *IndexExpr:
+		walk(v, n.Object)
+		walk(v, n.Index)
+	case *SetIndexExpr:
+		walk(v, n.Object)
+		walk(v, n.Index)
+		walk(v, n.Value)
+	case *ArrayLiteral:
+		walkExprs(v, n.Elements)
+	case *FnExpr:
+		walkTypes(v, n.ParamTypes)
+		walk(v, n.Result)
+		walk(v, n.Body)
+	case *MapLiteral:
+		for i, key := range n.Keys {
+			walk(v, key)
+			walk(v, n.Values[i])
+		}
+	case *StructLiteral:
+		walkExprs(v, n.Values)
+	case *InterpolatedString:
+		walkExprs(v, n.Exprs)
+	case *StructStmt, *ImportStmt, *BreakStmt, *ContinueStmt, *BadStmt, *BadExpr, *IdentExpr,
+		*LiteralExpr, *TypeExpr:
+		// No children
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", node))
+	}
+
+	v.Visit(nil)
+}
+
+// walk walks node unless it is nil, which it may be as an interface holding
+// a nil pointer.
+func walk(v Visitor, node Node) {
+	switch n := node.(type) {
+	case nil:
+		return
+	case *TypeExpr:
+		if n == nil {
+			return
+		}
+	}
+
+	Walk(v, node)
+}
+
+func walkStmts(v Visitor, stmts []Stmt) {
+	for _, stmt := range stmts {
+		walk(v, stmt)
+	}
+}
+
+func walkExprs(v Visitor, exprs []Expr) {
+	for _, expr := range exprs {
+		walk(v, expr)
+	}
+}
+
+func walkTypes(v Visitor, types []*TypeExpr) {
+	for _, t := range types {
+		walk(v, t)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree rooted at node in depth-first order, calling
+// f(node) for every node within it. If f returns true, Inspect visits the
+// children of the node, followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Rewrite replaces each node in the tree rooted at node with the result of
+// calling f on it, once its children have been rewritten, and returns the
+// result for node itself. f returns its argument to keep a node. Nodes are
+// rewritten in place, so the tree given is changed.
+//
+// A statement must be replaced by a statement and an expression by an
+// expression. Returning nil removes a node from a list, and leaves nil
+// where the node is not in a list.
+func Rewrite(node Node, f func(Node) Node) Node {
+	switch n := node.(type) {
+	case *Program:
+		n.Stmts = rewriteStmts(n.Stmts, f)
+	case *BlockStmt:
+		n.Body = rewriteStmts(n.Body, f)
+	case *IfStmt:
+		n.Cond = rewriteExpr(n.Cond, f)
+		n.If = rewriteStmt(n.If, f)
+		n.Else = rewriteStmt(n.Else, f)
+	case *WhileStmt:
+		n.Cond = rewriteExpr(n.Cond, f)
+		n.Body = rewriteStmt(n.Body, f)
+	case *ForStmt:
+		n.Init = rewriteStmt(n.Init, f)
+		n.Cond = rewriteExpr(n.Cond, f)
+		n.Inc = rewriteExpr(n.Inc, f)
+		n.Body = rewriteStmt(n.Body, f)
+	case *FnStmt:
+		n.ParamTypes = rewriteTypes(n.ParamTypes, f)
+		n.Result = rewriteType(n.Result, f)
+		n.Body = rewriteStmt(n.Body, f)
+	case *VarStmt:
+		n.Type = rewriteType(n.Type, f)
+		n.Value = rewriteExpr(n.Value, f)
+	case *ReturnStmt:
+		n.Value = rewriteExpr(n.Value, f)
+	case *ExprStmt:
+		n.Value = rewriteExpr(n.Value, f)
+	case *AssignExpr:
+		n.Value = rewriteExpr(n.Value, f)
+	case *BinaryExpr:
+		n.Left = rewriteExpr(n.Left, f)
+		n.Right = rewriteExpr(n.Right, f)
+	case *UnaryExpr:
+		n.Right = rewriteExpr(n.Right, f)
+	case *CallExpr:
+		n.Name = rewriteExpr(n.Name, f)
+		n.Args = rewriteExprs(n.Args, f)
+	case *GetExpr:
+		n.Object = rewriteExpr(n.Object, f)
+	case *SetExpr:
+		n.Object = rewriteExpr(n.Object, f)
+		n.Value = rewriteExpr(n.Value, f)
+	case *IndexExpr:
+		n.Object = rewriteExpr(n.Object, f)
+		n.Index = rewriteExpr(n.Index, f)
+	case *SetIndexExpr:
+		n.Object = rewriteExpr(n.Object, f)
+		n.Index = rewriteExpr(n.Index, f)
+		n.Value = rewriteExpr(n.Value, f)
+	case *ArrayLiteral:
+		n.Elements = rewriteExprs(n.Elements, f)
+	case *FnExpr:
+		n.ParamTypes = rewriteTypes(n.ParamTypes, f)
+		n.Result = rewriteType(n.Result, f)
+		n.Body = rewriteStmt(n.Body, f)
+	case *MapLiteral:
+		// Keys and values are removed in pairs, so a map stays whole
+		keys, values := n.Keys[:0], n.Values[:0]
+		for i := range n.Keys {
+			key, value := rewriteExpr(n.Keys[i], f), rewriteExpr(n.Values[i], f)
+			if key != nil && value != nil {
+				keys, values = append(keys, key), append(values, value)
+			}
+		}
+		n.Keys, n.Values = keys, values
+	case *StructLiteral:
+		// As in a map, a field is removed along with its value
+		fields, values := n.Fields[:0], n.Values[:0]
+		for i, field := range n.Fields {
+			if value := rewriteExpr(n.Values[i], f); value != nil {
+				fields, values = append(fields, field), append(values, value)
+			}
+		}
+		n.Fields, n.Values = fields, values
+	case *InterpolatedString:
+		// The text around a removed expression is joined into one literal
+		literals, exprs := n.Literals[:1], n.Exprs[:0]
+		for i, expr := range n.Exprs {
+			next := n.Literals[i+1]
+			if expr = rewriteExpr(expr, f); expr != nil {
+				literals, exprs = append(literals, next), append(exprs, expr)
+				continue
+			}
+
+			last := &literals[len(literals)-1]
+			last.Literal += next.Literal
+			last.End = next.End
+		}
+		n.Literals, n.Exprs = literals, exprs
+	case *StructStmt, *ImportStmt, *BreakStmt, *ContinueStmt, *BadStmt, *BadExpr, *IdentExpr,
+		*LiteralExpr, *TypeExpr:
+		// No children
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: unexpected node type %T", node))
+	}
+
+	return f(node)
+}
+
+func rewriteStmt(stmt Stmt, f func(Node) Node) Stmt {
+	if stmt == nil {
+		return nil
+	}
+
+	switch node := Rewrite(stmt, f).(type) {
+	case nil:
+		return nil
+	case Stmt:
+		return node
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace statement with %T", node))
+	}
+}
+
+func rewriteExpr(expr Expr, f func(Node) Node) Expr {
+	if expr == nil {
+		return nil
+	}
+
+	switch node := Rewrite(expr, f).(type) {
+	case nil:
+		return nil
+	case Expr:
+		return node
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace expression with %T", node))
+	}
+}
+
+func rewriteType(t *TypeExpr, f func(Node) Node) *TypeExpr {
+	if t == nil {
+		return nil
+	}
+
+	switch node := Rewrite(t, f).(type) {
+	case nil:
+		return nil
+	case *TypeExpr:
+		return node
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: cannot replace type with %T", node))
+	}
+}
+
+func rewriteStmts(stmts []Stmt, f func(Node) Node) []Stmt {
+	rewritten := stmts[:0]
+	for _, stmt := range stmts {
+		if stmt = rewriteStmt(stmt, f); stmt != nil {
+			rewritten = append(rewritten, stmt)
+		}
+	}
+	return rewritten
+}
+
+func rewriteExprs(exprs []Expr, f func(Node) Node) []Expr {
+	rewritten := exprs[:0]
+	for _, expr := range exprs {
+		if expr = rewriteExpr(expr, f); expr != nil {
+			rewritten = append(rewritten, expr)
+		}
+	}
+	return rewritten
+}
+
+// rewriteTypes keeps the nil types of parameters without one, since the
+// types are matched to parameters by index.
+func rewriteTypes(types []*TypeExpr, f func(Node) Node) []*TypeExpr {
+	for i, t := range types {
+		types[i] = rewriteType(t, f)
+	}
+	return types
+}
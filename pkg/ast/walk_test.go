@@ -0,0 +1,37 @@
+package ast_test
+
+import (
+	"testing"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/lexer"
+	"blorbo/pkg/parser"
+)
+
+// Removing the value of a field or map entry removes the whole entry, so
+// that the lists of a literal still line up.
+func TestRewriteRemovesPairs(t *testing.T) {
+	src := `P { x: 1, y: drop, z: 3 }; var m = { "a": drop, "b": 2 };`
+	tokens, _ := lexer.New(src).Scan()
+	program, err := parser.New(tokens).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ast.Rewrite(program, func(node ast.Node) ast.Node {
+		if ident, ok := node.(*ast.IdentExpr); ok && ident.Name.Literal == "drop" {
+			return nil
+		}
+		return node
+	})
+
+	literal := program.Stmts[0].(*ast.ExprStmt).Value.(*ast.StructLiteral)
+	if len(literal.Fields) != 2 || len(literal.Values) != 2 || literal.Fields[1].Literal != "z" {
+		t.Errorf("struct literal has fields %v and %d values", literal.Fields, len(literal.Values))
+	}
+
+	m := program.Stmts[1].(*ast.VarStmt).Value.(*ast.MapLiteral)
+	if len(m.Keys) != 1 || len(m.Values) != 1 {
+		t.Errorf("map literal has %d keys and %d values", len(m.Keys), len(m.Values))
+	}
+}
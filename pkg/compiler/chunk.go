@@ -0,0 +1,111 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+
+	"blorbo/pkg/object"
+	"blorbo/pkg/token"
+)
+
+// Chunk is a sequence of instructions together with the constants they
+// reference and the span of source code every byte of code was compiled
+// from.
+type Chunk struct {
+	Code      []byte
+	Constants []object.Value
+	Spans     []token.Span
+}
+
+// Function is a compiled function. The top-level script is compiled to a
+// Function named "script" taking no parameters. Anonymous functions have an
+// empty Name.
+type Function struct {
+	Name     string
+	Arity    int
+	Upvalues int
+	Chunk    *Chunk
+}
+
+func (*Function) Type() object.Type { return object.FunctionType }
+
+func (fn *Function) String() string {
+	if fn.Name == "" {
+		return "<fn>"
+	}
+
+	return fmt.Sprintf("<fn %s>", fn.Name)
+}
+
+func (fn *Function) NumParams() int {
+	return fn.Arity
+}
+
+func (c *Chunk) write(b byte, span token.Span) {
+	c.Code = append(c.Code, b)
+	c.Spans = append(c.Spans, span)
+}
+
+func (c *Chunk) ReadU16(offset int) int {
+	return int(c.Code[offset])<<8 | int(c.Code[offset+1])
+}
+
+func (c *Chunk) ReadU32(offset int) int {
+	return c.ReadU16(offset)<<16 | c.ReadU16(offset+2)
+}
+
+// Disassemble renders the chunk, and the chunks of any functions in its
+// constant pool, in a human readable form.
+func Disassemble(fn *Function) string {
+	var sb strings.Builder
+	disassemble(&sb, fn)
+
+	return sb.String()
+}
+
+func disassemble(sb *strings.Builder, fn *Function) {
+	chunk := fn.Chunk
+	fmt.Fprintf(sb, "== %s ==\n", fn)
+
+	for offset := 0; offset < len(chunk.Code); {
+		op := Opcode(chunk.Code[offset])
+		fmt.Fprintf(sb, "%04d %4d %-16s", offset, chunk.Spans[offset].Start.Line, op)
+		offset++
+
+		for _, width := range definitions[op].operands {
+			switch width {
+			case 1:
+				fmt.Fprintf(sb, " %d", chunk.Code[offset])
+			case 2:
+				fmt.Fprintf(sb, " %d", chunk.ReadU16(offset))
+			case 4:
+				fmt.Fprintf(sb, " %d", chunk.ReadU32(offset))
+			}
+			offset += width
+		}
+
+		if op == OpConstant || op == OpClosure {
+			fmt.Fprintf(sb, " (%s)", chunk.Constants[chunk.ReadU16(offset-2)])
+		}
+
+		if op == OpClosure {
+			upvalues := chunk.Constants[chunk.ReadU16(offset-2)].(*Function).Upvalues
+			for n := 0; n < upvalues; n++ {
+				if chunk.Code[offset] == 1 {
+					fmt.Fprintf(sb, " local %d", chunk.Code[offset+1])
+				} else {
+					fmt.Fprintf(sb, " upvalue %d", chunk.Code[offset+1])
+				}
+				offset += 2
+			}
+		}
+
+		sb.WriteString("\n")
+	}
+
+	for _, constant := range chunk.Constants {
+		if fn, ok := constant.(*Function); ok {
+			disassemble(sb, fn)
+		}
+	}
+}
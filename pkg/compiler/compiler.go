@@ -0,0 +1,1030 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/diag"
+	"blorbo/pkg/object"
+	"blorbo/pkg/token"
+)
+
+type local struct {
+	name     string
+	depth    int
+	captured bool
+}
+
+// loop is a loop being compiled, which the break and continue statements in
+// its body jump out of.
+type loop struct {
+	label string
+
+	// locals is the number of locals declared before the body, which a jump
+	// out of the body pops down to
+	locals int
+
+	// start is where a continue jumps to, or -1 when that is after the body,
+	// in which case the jumps in continues are patched once it is compiled
+	start     int
+	continues []int
+	breaks    []int
+}
+
+// upvalue is a variable captured from an enclosing function, either one of
+// its locals or one of its own upvalues.
+type upvalue struct {
+	index   int
+	isLocal bool
+}
+
+type Compiler struct {
+	fn         *Function
+	enclosing  *Compiler
+	locals     []local
+	upvalues   []upvalue
+	loops      []*loop
+	scopeDepth int
+	names      map[string]int
+	span       token.Span
+}
+
+func New() *Compiler {
+	fn := &Function{Name: "script", Chunk: &Chunk{}}
+	start := token.Pos{Line: 1}
+	return &Compiler{fn: fn, names: make(map[string]int), span: token.Span{Start: start, End: start}}
+}
+
+func newFunctionCompiler(enclosing *Compiler, name string, arity int) *Compiler {
+	fn := &Function{Name: name, Arity: arity, Chunk: &Chunk{}}
+	return &Compiler{
+		fn:         fn,
+		enclosing:  enclosing,
+		scopeDepth: 1,
+		names:      make(map[string]int),
+		span:       enclosing.span,
+	}
+}
+
+// Compile lowers a program to the top-level script function.
+func (c *Compiler) Compile(program *ast.Program) (*Function, error) {
+	for _, stmt := range program.Stmts {
+		if err := c.compileStmt(stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	c.emit(OpNull)
+	c.emit(OpReturn)
+
+	return c.fn, nil
+}
+
+func (c *Compiler) compileStmt(stmt ast.Stmt) error {
+	switch stmt := stmt.(type) {
+	case *ast.BlockStmt:
+		c.beginScope()
+		for _, stmt := range stmt.Body {
+			if err := c.compileStmt(stmt); err != nil {
+				return err
+			}
+		}
+		c.endScope()
+		return nil
+	case *ast.IfStmt:
+		return c.compileIf(stmt)
+	case *ast.WhileStmt:
+		return c.compileWhile(stmt)
+	case *ast.ForStmt:
+		return c.compileFor(stmt)
+	case *ast.StructStmt:
+		return c.compileStruct(stmt)
+	case *ast.FnStmt:
+		return c.compileFn(stmt)
+	case *ast.VarStmt:
+		return c.compileVar(stmt)
+	case *ast.ReturnStmt:
+		if err := c.compileExpr(stmt.Value); err != nil {
+			return err
+		}
+		c.emit(OpReturn)
+		return nil
+	case *ast.ImportStmt:
+		return c.compileImport(stmt)
+	case *ast.BreakStmt:
+		return c.compileBranch(stmt.Keyword, stmt.Label)
+	case *ast.ContinueStmt:
+		return c.compileBranch(stmt.Keyword, stmt.Label)
+	case *ast.ExprStmt:
+		return c.compileExprStmt(stmt.Value)
+	default:
+		msg := fmt.Sprintf("unknown statement %T", stmt)
+		return errors.New(msg)
+	}
+}
+
+func (c *Compiler) compileExprStmt(expr ast.Expr) error {
+	if err := c.compileExpr(expr); err != nil {
+		return err
+	}
+
+	c.emit(OpPop)
+	return nil
+}
+
+// compileBody compiles the body of an if, while or for statement. A body
+// that is not a block is still a scope of its own, so that a declaration
+// there does not outlive it.
+func (c *Compiler) compileBody(stmt ast.Stmt) error {
+	if _, ok := stmt.(*ast.BlockStmt); ok {
+		return c.compileStmt(stmt)
+	}
+
+	c.beginScope()
+	if err := c.compileStmt(stmt); err != nil {
+		return err
+	}
+	c.endScope()
+	return nil
+}
+
+func (c *Compiler) compileIf(stmt *ast.IfStmt) error {
+	if err := c.compileExpr(stmt.Cond); err != nil {
+		return err
+	}
+
+	elseJump := c.emitJump(OpJumpIfFalse)
+	if err := c.compileBody(stmt.If); err != nil {
+		return err
+	}
+
+	endJump := c.emitJump(OpJump)
+	if err := c.patchJump(elseJump); err != nil {
+		return err
+	}
+
+	if stmt.Else != nil {
+		if err := c.compileBody(stmt.Else); err != nil {
+			return err
+		}
+	}
+
+	return c.patchJump(endJump)
+}
+
+func (c *Compiler) compileWhile(stmt *ast.WhileStmt) error {
+	loopStart := len(c.fn.Chunk.Code)
+	if err := c.compileExpr(stmt.Cond); err != nil {
+		return err
+	}
+
+	exitJump := c.emitJump(OpJumpIfFalse)
+	c.beginLoop(stmt.Label, loopStart)
+	if err := c.compileBody(stmt.Body); err != nil {
+		return err
+	}
+
+	if err := c.emitJumpTo(OpJump, loopStart); err != nil {
+		return err
+	}
+	if err := c.patchJump(exitJump); err != nil {
+		return err
+	}
+
+	return c.endLoop()
+}
+
+func (c *Compiler) compileFor(stmt *ast.ForStmt) error {
+	c.beginScope()
+	loopVars := len(c.locals)
+
+	if stmt.Init != nil {
+		if err := c.compileStmt(stmt.Init); err != nil {
+			return err
+		}
+	}
+
+	loopStart := len(c.fn.Chunk.Code)
+	exitJump := -1
+	if stmt.Cond != nil {
+		if err := c.compileExpr(stmt.Cond); err != nil {
+			return err
+		}
+		exitJump = c.emitJump(OpJumpIfFalse)
+	}
+
+	c.beginLoop(stmt.Label, -1)
+	if err := c.compileBody(stmt.Body); err != nil {
+		return err
+	}
+
+	// A continue jumps here, so that the increment still runs
+	for _, jump := range c.loops[len(c.loops)-1].continues {
+		if err := c.patchJump(jump); err != nil {
+			return err
+		}
+	}
+
+	// Closures created in the body keep the values the loop variables had
+	// in that iteration, see OpCloseUpvalues
+	c.closeUpvalues(loopVars)
+
+	if stmt.Inc != nil {
+		if err := c.compileExprStmt(stmt.Inc); err != nil {
+			return err
+		}
+	}
+
+	if err := c.emitJumpTo(OpJump, loopStart); err != nil {
+		return err
+	}
+	if exitJump != -1 {
+		if err := c.patchJump(exitJump); err != nil {
+			return err
+		}
+	}
+
+	if err := c.endLoop(); err != nil {
+		return err
+	}
+
+	c.endScope()
+	return nil
+}
+
+func (c *Compiler) beginLoop(label token.Token, start int) {
+	c.loops = append(c.loops, &loop{label: label.Literal, locals: len(c.locals), start: start})
+}
+
+// endLoop patches the jumps of the break statements in the innermost loop to
+// the code after it.
+func (c *Compiler) endLoop() error {
+	l := c.loops[len(c.loops)-1]
+	c.loops = c.loops[:len(c.loops)-1]
+
+	for _, jump := range l.breaks {
+		if err := c.patchJump(jump); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compileBranch compiles a break or continue statement, which jumps out of
+// the innermost loop, or the loop with its label if it has one.
+func (c *Compiler) compileBranch(keyword token.Token, label token.Token) error {
+	c.span = keyword.Span()
+
+	var l *loop
+	for n := len(c.loops) - 1; n >= 0; n-- {
+		if label.Literal == "" || c.loops[n].label == label.Literal {
+			l = c.loops[n]
+			break
+		}
+	}
+	if l == nil {
+		return diag.New(diag.InvalidBranch, keyword.Span(), "%s outside of loop", keyword.Literal)
+	}
+
+	// The locals declared in the body are popped without ending their
+	// scopes, which the code after this statement is still in. Whether they
+	// are captured is not known until the whole body is compiled, so their
+	// upvalues are always closed.
+	if len(c.locals) > l.locals {
+		c.emitU8(OpCloseUpvalues, l.locals)
+		for n := len(c.locals); n > l.locals; n-- {
+			c.emit(OpPop)
+		}
+	}
+
+	switch {
+	case keyword.Type == token.Break:
+		l.breaks = append(l.breaks, c.emitJump(OpJump))
+	case l.start >= 0:
+		return c.emitJumpTo(OpJump, l.start)
+	default:
+		l.continues = append(l.continues, c.emitJump(OpJump))
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileStruct(stmt *ast.StructStmt) error {
+	c.span = stmt.Name.Span()
+
+	fields := make([]string, len(stmt.Fields))
+	for n, field := range stmt.Fields {
+		fields[n] = field.Literal
+	}
+
+	if err := c.emitConstant(object.NewStruct(stmt.Name.Literal, fields)); err != nil {
+		return err
+	}
+
+	return c.defineVariable(stmt.Name)
+}
+
+func (c *Compiler) compileFn(stmt *ast.FnStmt) error {
+	c.span = stmt.Name.Span()
+
+	// Declare a local function before compiling its body so that it can
+	// refer to itself
+	if c.scopeDepth > 0 {
+		if err := c.addLocal(stmt.Name); err != nil {
+			return err
+		}
+
+		return c.compileFunction(stmt.Name.Literal, stmt.Params, stmt.Body)
+	}
+
+	if err := c.compileFunction(stmt.Name.Literal, stmt.Params, stmt.Body); err != nil {
+		return err
+	}
+
+	return c.defineVariable(stmt.Name)
+}
+
+// compileFunction compiles a function body and emits an OpClosure pushing
+// it, together with the variables it captures.
+func (c *Compiler) compileFunction(name string, params []token.Token, body ast.Stmt) error {
+	fc := newFunctionCompiler(c, name, len(params))
+	for _, param := range params {
+		if err := fc.addLocal(param); err != nil {
+			return err
+		}
+	}
+
+	if err := fc.compileStmt(body); err != nil {
+		return err
+	}
+	fc.emit(OpNull)
+	fc.emit(OpReturn)
+
+	fc.fn.Upvalues = len(fc.upvalues)
+	index, err := c.addConstant(fc.fn)
+	if err != nil {
+		return err
+	}
+
+	c.emitU16(OpClosure, index)
+	for _, upvalue := range fc.upvalues {
+		isLocal := 0
+		if upvalue.isLocal {
+			isLocal = 1
+		}
+
+		c.fn.Chunk.write(byte(isLocal), c.span)
+		c.fn.Chunk.write(byte(upvalue.index), c.span)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileVar(stmt *ast.VarStmt) error {
+	c.span = stmt.Name.Span()
+
+	// As with FnStmt, a local initialized to a function is declared first so
+	// that the function can refer to itself
+	if _, ok := stmt.Value.(*ast.FnExpr); ok && c.scopeDepth > 0 {
+		if err := c.addLocal(stmt.Name); err != nil {
+			return err
+		}
+
+		return c.compileExpr(stmt.Value)
+	}
+
+	if stmt.Value != nil {
+		if err := c.compileExpr(stmt.Value); err != nil {
+			return err
+		}
+	} else {
+		c.emit(OpNull)
+	}
+
+	return c.defineVariable(stmt.Name)
+}
+
+func (c *Compiler) compileImport(stmt *ast.ImportStmt) error {
+	c.span = stmt.Keyword.Span()
+
+	index, err := c.addConstant(object.String(stmt.Path.Literal))
+	if err != nil {
+		return err
+	}
+
+	c.emitU16(OpImport, index)
+	return c.defineVariable(stmt.Name)
+}
+
+// defineVariable binds the value on top of the stack to name, either as a
+// global or by leaving it in place as a new local slot.
+func (c *Compiler) defineVariable(name token.Token) error {
+	if c.scopeDepth > 0 {
+		return c.addLocal(name)
+	}
+
+	index, err := c.nameConstant(name.Literal)
+	if err != nil {
+		return err
+	}
+
+	c.emitU16(OpDefineGlobal, index)
+	return nil
+}
+
+func (c *Compiler) compileExpr(expr ast.Expr) error {
+	switch expr := expr.(type) {
+	case *ast.AssignExpr:
+		return c.compileAssign(expr)
+	case *ast.BinaryExpr:
+		return c.compileBinary(expr)
+	case *ast.UnaryExpr:
+		return c.compileUnary(expr)
+	case *ast.CallExpr:
+		return c.compileCall(expr)
+	case *ast.GetExpr:
+		return c.compileGet(expr)
+	case *ast.SetExpr:
+		return c.compileSet(expr)
+	case *ast.IndexExpr:
+		return c.compileIndex(expr)
+	case *ast.SetIndexExpr:
+		return c.compileSetIndex(expr)
+	case *ast.ArrayLiteral:
+		return c.compileArrayLiteral(expr)
+	case *ast.MapLiteral:
+		return c.compileMapLiteral(expr)
+	case *ast.FnExpr:
+		c.span = expr.Keyword.Span()
+		return c.compileFunction("", expr.Params, expr.Body)
+	case *ast.StructLiteral:
+		return c.compileStructLiteral(expr)
+	case *ast.IdentExpr:
+		return c.compileIdent(expr)
+	case *ast.LiteralExpr:
+		return c.compileLiteral(expr)
+	case *ast.InterpolatedString:
+		return c.compileInterpolatedString(expr)
+	case nil:
+		c.emit(OpNull)
+		return nil
+	default:
+		msg := fmt.Sprintf("unknown expression %T", expr)
+		return errors.New(msg)
+	}
+}
+
+func (c *Compiler) compileAssign(expr *ast.AssignExpr) error {
+	load := func() error {
+		c.span = expr.Name.Span()
+		return c.emitVariable(expr.Name, OpGetLocal, OpGetUpvalue, OpGetGlobal)
+	}
+
+	if err := c.compileAssigned(expr.Op, expr.Value, load); err != nil {
+		return err
+	}
+
+	c.span = expr.Name.Span()
+	return c.emitVariable(expr.Name, OpSetLocal, OpSetUpvalue, OpSetGlobal)
+}
+
+// compileAssigned compiles the value stored by an assignment with the
+// operator op. A compound operator is applied to the current value of the
+// target, which load pushes, and to value.
+func (c *Compiler) compileAssigned(op token.Token, value ast.Expr, load func() error) error {
+	binary, ok := token.Compound(op.Type)
+	if !ok {
+		return c.compileExpr(value)
+	}
+
+	if err := load(); err != nil {
+		return err
+	}
+
+	if err := c.compileExpr(value); err != nil {
+		return err
+	}
+
+	c.span = op.Span()
+	c.emit(binaryOps[binary])
+	return nil
+}
+
+var binaryOps = map[token.TokenType]Opcode{
+	token.Mul:          OpMul,
+	token.Div:          OpDiv,
+	token.Mod:          OpMod,
+	token.Add:          OpAdd,
+	token.Sub:          OpSub,
+	token.Equal:        OpEqual,
+	token.NotEqual:     OpNotEqual,
+	token.Greater:      OpGreater,
+	token.GreaterEqual: OpGreaterEqual,
+	token.Less:         OpLess,
+	token.LessEqual:    OpLessEqual,
+	token.BitAnd:       OpBitAnd,
+	token.BitOr:        OpBitOr,
+	token.BitXor:       OpBitXor,
+	token.RightShift:   OpRightShift,
+	token.LeftShift:    OpLeftShift,
+}
+
+func (c *Compiler) compileBinary(expr *ast.BinaryExpr) error {
+	if err := c.compileExpr(expr.Left); err != nil {
+		return err
+	}
+
+	c.span = expr.Op.Span()
+
+	// Logical operators short-circuit and yield the deciding operand
+	if expr.Op.Type == token.And || expr.Op.Type == token.Or {
+		c.emit(OpDup)
+
+		var endJump int
+		if expr.Op.Type == token.And {
+			endJump = c.emitJump(OpJumpIfFalse)
+		} else {
+			endJump = c.emitJump(OpJumpIfTrue)
+		}
+
+		c.emit(OpPop)
+		if err := c.compileExpr(expr.Right); err != nil {
+			return err
+		}
+
+		return c.patchJump(endJump)
+	}
+
+	if err := c.compileExpr(expr.Right); err != nil {
+		return err
+	}
+
+	op, ok := binaryOps[expr.Op.Type]
+	if !ok {
+		return diag.New(diag.InvalidOperand, expr.Op.Span(), "unknown operator '%s'", expr.Op.Literal)
+	}
+
+	c.span = expr.Op.Span()
+	c.emit(op)
+	return nil
+}
+
+var unaryOps = map[token.TokenType]Opcode{
+	token.Add:    OpPos,
+	token.Sub:    OpNeg,
+	token.Not:    OpNot,
+	token.BitNot: OpBitNot,
+}
+
+func (c *Compiler) compileUnary(expr *ast.UnaryExpr) error {
+	if err := c.compileExpr(expr.Right); err != nil {
+		return err
+	}
+
+	op, ok := unaryOps[expr.Op.Type]
+	if !ok {
+		return diag.New(diag.InvalidOperand, expr.Op.Span(), "unknown operator '%s'", expr.Op.Literal)
+	}
+
+	c.span = expr.Op.Span()
+	c.emit(op)
+	return nil
+}
+
+func (c *Compiler) compileCall(expr *ast.CallExpr) error {
+	if err := c.compileExpr(expr.Name); err != nil {
+		return err
+	}
+
+	for _, arg := range expr.Args {
+		if err := c.compileExpr(arg); err != nil {
+			return err
+		}
+	}
+
+	if len(expr.Args) > math.MaxUint8 {
+		return diag.New(diag.TooLarge, expr.Paren.Span(), "too many arguments")
+	}
+
+	c.span = expr.Paren.Span()
+	c.emitU8(OpCall, len(expr.Args))
+	return nil
+}
+
+func (c *Compiler) compileGet(expr *ast.GetExpr) error {
+	if err := c.compileExpr(expr.Object); err != nil {
+		return err
+	}
+
+	index, err := c.nameConstant(expr.Name.Literal)
+	if err != nil {
+		return err
+	}
+
+	c.span = expr.Name.Span()
+	c.emitU16(OpGetField, index)
+	return nil
+}
+
+func (c *Compiler) compileSet(expr *ast.SetExpr) error {
+	if err := c.compileExpr(expr.Object); err != nil {
+		return err
+	}
+
+	index, err := c.nameConstant(expr.Name.Literal)
+	if err != nil {
+		return err
+	}
+
+	// The object stays on the stack for OpSetField
+	load := func() error {
+		c.emit(OpDup)
+		c.span = expr.Name.Span()
+		c.emitU16(OpGetField, index)
+		return nil
+	}
+
+	if err := c.compileAssigned(expr.Op, expr.Value, load); err != nil {
+		return err
+	}
+
+	c.span = expr.Name.Span()
+	c.emitU16(OpSetField, index)
+	return nil
+}
+
+func (c *Compiler) compileIndex(expr *ast.IndexExpr) error {
+	if err := c.compileExpr(expr.Object); err != nil {
+		return err
+	}
+
+	if err := c.compileExpr(expr.Index); err != nil {
+		return err
+	}
+
+	c.span = expr.Bracket.Span()
+	c.emit(OpIndex)
+	return nil
+}
+
+func (c *Compiler) compileSetIndex(expr *ast.SetIndexExpr) error {
+	for _, expr := range []ast.Expr{expr.Object, expr.Index} {
+		if err := c.compileExpr(expr); err != nil {
+			return err
+		}
+	}
+
+	// The object and index stay on the stack for OpSetIndex
+	load := func() error {
+		c.emit(OpDup2)
+		c.span = expr.Bracket.Span()
+		c.emit(OpIndex)
+		return nil
+	}
+
+	if err := c.compileAssigned(expr.Op, expr.Value, load); err != nil {
+		return err
+	}
+
+	c.span = expr.Bracket.Span()
+	c.emit(OpSetIndex)
+	return nil
+}
+
+func (c *Compiler) compileArrayLiteral(expr *ast.ArrayLiteral) error {
+	for _, element := range expr.Elements {
+		if err := c.compileExpr(element); err != nil {
+			return err
+		}
+	}
+
+	if len(expr.Elements) > math.MaxUint16 {
+		return diag.New(diag.TooLarge, expr.Bracket.Span(), "too many array elements")
+	}
+
+	c.span = expr.Bracket.Span()
+	c.emitU16(OpArray, len(expr.Elements))
+	return nil
+}
+
+// compileMapLiteral pushes a key and value pair for each entry, which OpMap
+// replaces with the new map.
+func (c *Compiler) compileMapLiteral(expr *ast.MapLiteral) error {
+	for n, key := range expr.Keys {
+		if err := c.compileExpr(key); err != nil {
+			return err
+		}
+
+		if err := c.compileExpr(expr.Values[n]); err != nil {
+			return err
+		}
+	}
+
+	if len(expr.Keys) > math.MaxUint16 {
+		return diag.New(diag.TooLarge, expr.Brace.Span(), "too many map entries")
+	}
+
+	c.span = expr.Brace.Span()
+	c.emitU16(OpMap, len(expr.Keys))
+	return nil
+}
+
+// compileStructLiteral pushes the struct followed by a name and value pair
+// for each field, which OpInstance replaces with the new instance.
+func (c *Compiler) compileStructLiteral(expr *ast.StructLiteral) error {
+	if err := c.compileIdent(&ast.IdentExpr{Name: expr.Name}); err != nil {
+		return err
+	}
+
+	if len(expr.Fields) > math.MaxUint8 {
+		return diag.New(diag.TooLarge, expr.Name.Span(), "too many fields")
+	}
+
+	for n, field := range expr.Fields {
+		index, err := c.nameConstant(field.Literal)
+		if err != nil {
+			return err
+		}
+
+		c.emitU16(OpConstant, index)
+		if err := c.compileExpr(expr.Values[n]); err != nil {
+			return err
+		}
+	}
+
+	c.span = expr.Name.Span()
+	c.emitU8(OpInstance, len(expr.Fields))
+	return nil
+}
+
+func (c *Compiler) compileIdent(expr *ast.IdentExpr) error {
+	c.span = expr.Name.Span()
+	return c.emitVariable(expr.Name, OpGetLocal, OpGetUpvalue, OpGetGlobal)
+}
+
+// emitVariable emits the local, upvalue or global form of a variable access
+// depending on where name is declared.
+func (c *Compiler) emitVariable(name token.Token, local, upvalue, global Opcode) error {
+	if slot := c.resolveLocal(name.Literal); slot != -1 {
+		c.emitU8(local, slot)
+		return nil
+	}
+
+	index, err := c.resolveUpvalue(name)
+	if err != nil {
+		return err
+	}
+
+	if index != -1 {
+		c.emitU8(upvalue, index)
+		return nil
+	}
+
+	index, err = c.nameConstant(name.Literal)
+	if err != nil {
+		return err
+	}
+
+	c.emitU16(global, index)
+	return nil
+}
+
+func (c *Compiler) compileLiteral(expr *ast.LiteralExpr) error {
+	c.span = expr.Value.Span()
+
+	switch expr.Value.Type {
+	case token.Int:
+		value, err := token.ParseInt(expr.Value.Literal)
+		if err != nil {
+			return diag.New(diag.NumberOverflow, expr.Value.Span(), "%s", err)
+		}
+		return c.emitConstant(object.Integer(value))
+	case token.Float:
+		value, err := token.ParseFloat(expr.Value.Literal)
+		if err != nil {
+			return diag.New(diag.NumberOverflow, expr.Value.Span(), "%s", err)
+		}
+		return c.emitConstant(object.Number(value))
+	case token.String:
+		return c.emitConstant(object.String(expr.Value.Literal))
+	case token.True:
+		c.emit(OpTrue)
+	case token.False:
+		c.emit(OpFalse)
+	default:
+		c.emit(OpNull)
+	}
+
+	return nil
+}
+
+// compileInterpolatedString adds each part of the string in turn to its first
+// literal, which makes every part a string as "+" does.
+func (c *Compiler) compileInterpolatedString(expr *ast.InterpolatedString) error {
+	c.span = expr.Literals[0].Span()
+	if err := c.emitConstant(object.String(expr.Literals[0].Literal)); err != nil {
+		return err
+	}
+
+	for n, part := range expr.Exprs {
+		if err := c.compileExpr(part); err != nil {
+			return err
+		}
+		c.emit(OpAdd)
+
+		literal := expr.Literals[n+1]
+		if literal.Literal == "" {
+			continue
+		}
+
+		c.span = literal.Span()
+		if err := c.emitConstant(object.String(literal.Literal)); err != nil {
+			return err
+		}
+		c.emit(OpAdd)
+	}
+
+	return nil
+}
+
+func (c *Compiler) beginScope() {
+	c.scopeDepth++
+}
+
+func (c *Compiler) endScope() {
+	c.scopeDepth--
+
+	n := len(c.locals)
+	for n > 0 && c.locals[n-1].depth > c.scopeDepth {
+		n--
+	}
+
+	c.closeUpvalues(n)
+	for len(c.locals) > n {
+		c.emit(OpPop)
+		c.locals = c.locals[:len(c.locals)-1]
+	}
+}
+
+// closeUpvalues emits an OpCloseUpvalues if any local from slot onwards has
+// been captured by a closure.
+func (c *Compiler) closeUpvalues(slot int) {
+	for _, local := range c.locals[slot:] {
+		if local.captured {
+			c.emitU8(OpCloseUpvalues, slot)
+			return
+		}
+	}
+}
+
+func (c *Compiler) addLocal(name token.Token) error {
+	for n := len(c.locals) - 1; n >= 0 && c.locals[n].depth == c.scopeDepth; n-- {
+		if c.locals[n].name == name.Literal {
+			return diag.New(diag.Redeclared, name.Span(), "variable '%s' already declared", name.Literal)
+		}
+	}
+
+	if len(c.locals) > math.MaxUint8 {
+		return diag.New(diag.TooLarge, name.Span(), "too many local variables")
+	}
+
+	c.locals = append(c.locals, local{name: name.Literal, depth: c.scopeDepth})
+	return nil
+}
+
+// resolveLocal returns the stack slot of a local variable, or -1 if name is
+// not a local of this function.
+func (c *Compiler) resolveLocal(name string) int {
+	for n := len(c.locals) - 1; n >= 0; n-- {
+		if c.locals[n].name == name {
+			return n
+		}
+	}
+
+	return -1
+}
+
+// resolveUpvalue returns the index of the upvalue capturing name from an
+// enclosing function, or -1 if name refers to a global.
+func (c *Compiler) resolveUpvalue(name token.Token) (int, error) {
+	if c.enclosing == nil {
+		return -1, nil
+	}
+
+	if slot := c.enclosing.resolveLocal(name.Literal); slot != -1 {
+		c.enclosing.locals[slot].captured = true
+		return c.addUpvalue(name, slot, true)
+	}
+
+	index, err := c.enclosing.resolveUpvalue(name)
+	if err != nil || index == -1 {
+		return index, err
+	}
+
+	return c.addUpvalue(name, index, false)
+}
+
+func (c *Compiler) addUpvalue(name token.Token, index int, isLocal bool) (int, error) {
+	for n, upvalue := range c.upvalues {
+		if upvalue.index == index && upvalue.isLocal == isLocal {
+			return n, nil
+		}
+	}
+
+	if len(c.upvalues) > math.MaxUint8 {
+		return 0, diag.New(diag.TooLarge, name.Span(), "too many captured variables")
+	}
+
+	c.upvalues = append(c.upvalues, upvalue{index: index, isLocal: isLocal})
+	return len(c.upvalues) - 1, nil
+}
+
+func (c *Compiler) nameConstant(name string) (int, error) {
+	if index, ok := c.names[name]; ok {
+		return index, nil
+	}
+
+	index, err := c.addConstant(object.String(name))
+	if err != nil {
+		return 0, err
+	}
+
+	c.names[name] = index
+	return index, nil
+}
+
+func (c *Compiler) addConstant(value object.Value) (int, error) {
+	chunk := c.fn.Chunk
+	if len(chunk.Constants) > math.MaxUint16 {
+		return 0, diag.New(diag.TooLarge, c.span, "too many constants")
+	}
+
+	chunk.Constants = append(chunk.Constants, value)
+	return len(chunk.Constants) - 1, nil
+}
+
+func (c *Compiler) emitConstant(value object.Value) error {
+	index, err := c.addConstant(value)
+	if err != nil {
+		return err
+	}
+
+	c.emitU16(OpConstant, index)
+	return nil
+}
+
+func (c *Compiler) emit(op Opcode) {
+	c.fn.Chunk.write(byte(op), c.span)
+}
+
+func (c *Compiler) emitU8(op Opcode, operand int) {
+	c.emit(op)
+	c.fn.Chunk.write(byte(operand), c.span)
+}
+
+func (c *Compiler) emitU16(op Opcode, operand int) {
+	c.emit(op)
+	c.fn.Chunk.write(byte(operand>>8), c.span)
+	c.fn.Chunk.write(byte(operand), c.span)
+}
+
+// emitJump emits a jump with a placeholder target and returns the offset of
+// the target for patchJump.
+func (c *Compiler) emitJump(op Opcode) int {
+	c.emit(op)
+	for n := 0; n < 4; n++ {
+		c.fn.Chunk.write(0xff, c.span)
+	}
+	return len(c.fn.Chunk.Code) - 4
+}
+
+// emitJumpTo emits a jump to a target that is already compiled.
+func (c *Compiler) emitJumpTo(op Opcode, target int) error {
+	c.patchTarget(c.emitJump(op), target)
+	return c.checkTarget(target)
+}
+
+// patchJump sets the target of the jump emitted at offset to the end of the
+// code compiled so far.
+func (c *Compiler) patchJump(offset int) error {
+	target := len(c.fn.Chunk.Code)
+	c.patchTarget(offset, target)
+	return c.checkTarget(target)
+}
+
+func (c *Compiler) patchTarget(offset int, target int) {
+	for n := 0; n < 4; n++ {
+		c.fn.Chunk.Code[offset+n] = byte(uint64(target) >> (24 - 8*n))
+	}
+}
+
+func (c *Compiler) checkTarget(target int) error {
+	if target < 0 || uint64(target) > math.MaxUint32 {
+		return diag.New(diag.TooLarge, c.span, "too much code in function")
+	}
+
+	return nil
+}
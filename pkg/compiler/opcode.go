@@ -0,0 +1,146 @@
+package compiler
+
+type Opcode byte
+
+const (
+	// Constants
+	OpConstant Opcode = iota // OpConstant index:u16
+	OpNull
+	OpTrue
+	OpFalse
+
+	// Stack manipulation
+	OpPop
+	OpDup
+	OpDup2
+
+	// Variables
+	OpDefineGlobal // OpDefineGlobal name:u16
+	OpGetGlobal    // OpGetGlobal name:u16
+	OpSetGlobal    // OpSetGlobal name:u16
+	OpGetLocal     // OpGetLocal slot:u8
+	OpSetLocal     // OpSetLocal slot:u8
+	OpGetUpvalue   // OpGetUpvalue index:u8
+	OpSetUpvalue   // OpSetUpvalue index:u8
+
+	// Structs
+	OpInstance // OpInstance fields:u8
+	OpGetField // OpGetField name:u16
+	OpSetField // OpSetField name:u16
+
+	// Arrays
+	OpArray // OpArray elements:u16
+	OpIndex
+	OpSetIndex
+
+	// Maps
+	OpMap // OpMap entries:u16
+
+	// Mathematical operations
+	OpMul
+	OpDiv
+	OpMod
+	OpAdd
+	OpSub
+	OpNeg
+	OpPos
+
+	// Logical operations
+	OpEqual
+	OpNotEqual
+	OpNot
+	OpGreater
+	OpGreaterEqual
+	OpLess
+	OpLessEqual
+
+	// Bitwise operations
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpBitNot
+	OpRightShift
+	OpLeftShift
+
+	// Control flow
+	OpJump        // OpJump target:u32
+	OpJumpIfFalse // OpJumpIfFalse target:u32
+	OpJumpIfTrue  // OpJumpIfTrue target:u32
+	OpCall        // OpCall argc:u8
+	OpReturn
+
+	// Closures
+	OpClosure       // OpClosure function:u16 ( isLocal:u8 index:u8 )*
+	OpCloseUpvalues // OpCloseUpvalues slot:u8
+
+	// Modules
+	OpImport // OpImport path:u16
+)
+
+type definition struct {
+	name     string
+	operands []int
+}
+
+var definitions = map[Opcode]definition{
+	OpConstant:     {"OpConstant", []int{2}},
+	OpNull:         {"OpNull", nil},
+	OpTrue:         {"OpTrue", nil},
+	OpFalse:        {"OpFalse", nil},
+	OpPop:          {"OpPop", nil},
+	OpDup:          {"OpDup", nil},
+	OpDup2:         {"OpDup2", nil},
+	OpDefineGlobal: {"OpDefineGlobal", []int{2}},
+	OpGetGlobal:    {"OpGetGlobal", []int{2}},
+	OpSetGlobal:    {"OpSetGlobal", []int{2}},
+	OpGetLocal:     {"OpGetLocal", []int{1}},
+	OpSetLocal:     {"OpSetLocal", []int{1}},
+	OpGetUpvalue:   {"OpGetUpvalue", []int{1}},
+	OpSetUpvalue:   {"OpSetUpvalue", []int{1}},
+	OpInstance:     {"OpInstance", []int{1}},
+	OpGetField:     {"OpGetField", []int{2}},
+	OpSetField:     {"OpSetField", []int{2}},
+	OpArray:        {"OpArray", []int{2}},
+	OpIndex:        {"OpIndex", nil},
+	OpSetIndex:     {"OpSetIndex", nil},
+	OpMap:          {"OpMap", []int{2}},
+	OpMul:          {"OpMul", nil},
+	OpDiv:          {"OpDiv", nil},
+	OpMod:          {"OpMod", nil},
+	OpAdd:          {"OpAdd", nil},
+	OpSub:          {"OpSub", nil},
+	OpNeg:          {"OpNeg", nil},
+	OpPos:          {"OpPos", nil},
+	OpEqual:        {"OpEqual", nil},
+	OpNotEqual:     {"OpNotEqual", nil},
+	OpNot:          {"OpNot", nil},
+	OpGreater:      {"OpGreater", nil},
+	OpGreaterEqual: {"OpGreaterEqual", nil},
+	OpLess:         {"OpLess", nil},
+	OpLessEqual:    {"OpLessEqual", nil},
+	OpBitAnd:       {"OpBitAnd", nil},
+	OpBitOr:        {"OpBitOr", nil},
+	OpBitXor:       {"OpBitXor", nil},
+	OpBitNot:       {"OpBitNot", nil},
+	OpRightShift:   {"OpRightShift", nil},
+	OpLeftShift:    {"OpLeftShift", nil},
+	OpJump:         {"OpJump", []int{4}},
+	OpJumpIfFalse:  {"OpJumpIfFalse", []int{4}},
+	OpJumpIfTrue:   {"OpJumpIfTrue", []int{4}},
+	OpCall:         {"OpCall", []int{1}},
+	OpReturn:       {"OpReturn", nil},
+
+	// OpClosure is followed by a pair of operands for each upvalue of the
+	// function, see Disassemble
+	OpClosure:       {"OpClosure", []int{2}},
+	OpCloseUpvalues: {"OpCloseUpvalues", []int{1}},
+	OpImport:        {"OpImport", []int{2}},
+}
+
+func (op Opcode) String() string {
+	if def, ok := definitions[op]; ok {
+		return def.name
+	}
+
+	return "OpUnknown"
+}
@@ -0,0 +1,194 @@
+package diag
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"blorbo/pkg/token"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	}
+
+	return "unknown"
+}
+
+// Code identifies the kind of problem a diagnostic reports, so tools can
+// handle each kind without matching on messages.
+type Code string
+
+const (
+	// Lexer
+	UnexpectedChar     Code = "unexpected-char"
+	UnterminatedString Code = "unterminated-string"
+	MalformedNumber    Code = "malformed-number"
+	NumberOverflow     Code = "number-overflow"
+	InvalidEscape      Code = "invalid-escape"
+
+	// Parser
+	Syntax Code = "syntax"
+
+	// Resolver
+	Undefined      Code = "undefined"
+	UsedBeforeDecl Code = "used-before-declaration"
+	Redeclared     Code = "redeclared"
+	InvalidReturn  Code = "invalid-return"
+	InvalidImport  Code = "invalid-import"
+	InvalidBranch  Code = "invalid-branch"
+
+	// Type checker
+	TypeMismatch   Code = "type-mismatch"
+	UnknownType    Code = "unknown-type"
+	InvalidOperand Code = "invalid-operand"
+	ArgCount       Code = "arg-count"
+	NotCallable    Code = "not-callable"
+	MissingReturn  Code = "missing-return"
+
+	// Modules
+	ModuleNotFound Code = "module-not-found"
+	ImportCycle    Code = "import-cycle"
+
+	// Execution
+	TooLarge Code = "too-large"
+	Runtime  Code = "runtime"
+)
+
+// Diagnostic is a problem found in source code, pointing at the span of code
+// it is about.
+type Diagnostic struct {
+	Severity Severity
+	Code     Code
+	Message  string
+	File     string
+	Span     token.Span
+}
+
+// New returns an error diagnostic.
+func New(code Code, span token.Span, format string, args ...interface{}) *Diagnostic {
+	return &Diagnostic{
+		Severity: Error,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Span:     span,
+	}
+}
+
+// Error renders the diagnostic as "file:line:column: severity: message",
+// leaving out the file name when there is none and the column when it is
+// not known.
+func (d *Diagnostic) Error() string {
+	var sb strings.Builder
+	if d.File != "" {
+		sb.WriteString(d.File + ":")
+	}
+
+	start := d.Span.Start
+	if start.Column > 0 {
+		fmt.Fprintf(&sb, "%d:%d: ", start.Line, start.Column)
+	} else {
+		fmt.Fprintf(&sb, "%d: ", start.Line)
+	}
+
+	fmt.Fprintf(&sb, "%s: %s", d.Severity, d.Message)
+	return sb.String()
+}
+
+// MarshalJSON encodes the diagnostic as a flat object for tools to read.
+func (d *Diagnostic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		File      string `json:"file,omitempty"`
+		Line      int    `json:"line"`
+		Column    int    `json:"column"`
+		EndLine   int    `json:"endLine"`
+		EndColumn int    `json:"endColumn"`
+		Severity  string `json:"severity"`
+		Code      Code   `json:"code"`
+		Message   string `json:"message"`
+	}{
+		File:      d.File,
+		Line:      d.Span.Start.Line,
+		Column:    d.Span.Start.Column,
+		EndLine:   d.Span.End.Line,
+		EndColumn: d.Span.End.Column,
+		Severity:  d.Severity.String(),
+		Code:      d.Code,
+		Message:   d.Message,
+	})
+}
+
+// List is a list of diagnostics, rendered one per line as an error.
+type List []*Diagnostic
+
+func (l List) Error() string {
+	lines := make([]string, len(l))
+	for n, d := range l {
+		lines[n] = d.Error()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Err returns the list as an error, or nil if it is empty.
+func (l List) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+
+	return l
+}
+
+// Join returns the diagnostics in errs, each a *Diagnostic, a List or nil,
+// as one List sorted by position. It returns nil if there are none.
+func Join(errs ...error) error {
+	var joined List
+	for _, err := range errs {
+		var list List
+		var d *Diagnostic
+		if errors.As(err, &list) {
+			joined = append(joined, list...)
+		} else if errors.As(err, &d) {
+			joined = append(joined, d)
+		}
+	}
+
+	sort.SliceStable(joined, func(i, j int) bool {
+		return joined[i].Span.Start.Offset < joined[j].Span.Start.Offset
+	})
+
+	return joined.Err()
+}
+
+// SetFile sets the file name of the diagnostics in err, which may be a
+// *Diagnostic or a List, that do not have one yet. Other errors are left
+// unchanged.
+func SetFile(err error, file string) {
+	var list List
+	if errors.As(err, &list) {
+		for _, d := range list {
+			if d.File == "" {
+				d.File = file
+			}
+		}
+		return
+	}
+
+	var d *Diagnostic
+	if errors.As(err, &d) && d.File == "" {
+		d.File = file
+	}
+}
@@ -0,0 +1,639 @@
+package format
+
+import (
+	"strings"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/lexer"
+	"blorbo/pkg/parser"
+	"blorbo/pkg/token"
+)
+
+const indent = "    "
+
+// Source formats src, which must parse without errors.
+func Source(src string) (string, error) {
+	l := lexer.New(src)
+	l.KeepComments()
+
+	tokens, err := l.Scan()
+	if err != nil {
+		return "", err
+	}
+
+	var code []token.Token
+	for _, tok := range tokens {
+		if tok.Type != token.Comment {
+			code = append(code, tok)
+		}
+	}
+
+	program, err := parser.New(code).Parse()
+	if err != nil {
+		return "", err
+	}
+
+	return Program(program, tokens), nil
+}
+
+// Program renders program in the canonical style: four spaces of
+// indentation, opening braces on the line of the statement they belong to
+// and at most one blank line between statements, kept where the source had
+// any. The tokens are those program was parsed from, scanned with comments
+// kept.
+//
+// A comment after a statement or an item of a list on its line stays there,
+// and other comments are placed on their own lines before the statement or
+// item that follows them.
+func Program(program *ast.Program, tokens []token.Token) string {
+	p := &printer{}
+
+	// The end of the code before each comment tells whether the comment
+	// follows a statement or an item of a list. Their spans do not include
+	// the ";" of an expression statement or the "," after an item, so the
+	// end of the code before those is kept too.
+	var prev, beforeSep int
+	for _, tok := range tokens {
+		switch tok.Type {
+		case token.Comment:
+			p.comments = append(p.comments, comment{tok, prev, beforeSep})
+		case token.Semicolon, token.Comma:
+			beforeSep = prev
+			prev = tok.End.Offset
+		default:
+			prev = tok.End.Offset
+			beforeSep = prev
+		}
+	}
+
+	p.stmts(program.Stmts, -1)
+	return p.sb.String()
+}
+
+type comment struct {
+	token.Token
+
+	// after is the offset of the end of the code before the comment, and
+	// beforeSep the same ignoring a ";" or "," just before it
+	after     int
+	beforeSep int
+}
+
+type printer struct {
+	sb       strings.Builder
+	depth    int
+	comments []comment
+
+	// line is the source line of the last statement or comment printed, or 0
+	// at the start of a block, where no blank line is kept
+	line int
+}
+
+// stmts prints a list of statements, each on its own lines, followed by the
+// comments before offset end. An end of -1 prints every remaining comment.
+func (p *printer) stmts(stmts []ast.Stmt, end int) {
+	p.line = 0
+
+	for _, stmt := range stmts {
+		// Empty statements are dropped
+		if stmt == nil {
+			continue
+		}
+
+		span := spanOf(stmt)
+		p.leading(span.Start.Offset)
+		p.blank(span.Start.Line)
+
+		p.write(p.indent())
+		p.stmt(stmt)
+		p.trailing(span.End)
+		p.write("\n")
+	}
+
+	p.leading(end)
+}
+
+// leading prints the comments before offset on their own lines. An offset
+// of -1 prints every remaining comment.
+func (p *printer) leading(offset int) {
+	for len(p.comments) > 0 && (offset < 0 || p.comments[0].Offset < offset) {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+
+		p.blank(comment.Line)
+		p.write(p.indent() + text(comment) + "\n")
+	}
+}
+
+// trailing prints the comment on the line a statement ends on after it,
+// and reports whether there was one.
+func (p *printer) trailing(end token.Pos) bool {
+	p.line = end.Line
+
+	if len(p.comments) == 0 {
+		return false
+	}
+
+	comment := p.comments[0]
+	if comment.Line == end.Line && (comment.after == end.Offset || comment.beforeSep == end.Offset) {
+		p.comments = p.comments[1:]
+		p.write(" " + text(comment))
+		return true
+	}
+
+	return false
+}
+
+// blank prints a blank line if the source had blank lines between the last
+// thing printed and line.
+func (p *printer) blank(line int) {
+	if p.line > 0 && line > p.line+1 {
+		p.write("\n")
+	}
+
+	p.line = line
+}
+
+func text(comment comment) string {
+	return strings.TrimRight(comment.Literal, " \t\r")
+}
+
+func (p *printer) write(s string) {
+	p.sb.WriteString(s)
+}
+
+func (p *printer) indent() string {
+	return strings.Repeat(indent, p.depth)
+}
+
+func (p *printer) stmt(stmt ast.Stmt) {
+	switch stmt := stmt.(type) {
+	case *ast.BlockStmt:
+		p.block(stmt)
+	case *ast.IfStmt:
+		p.write("if (")
+		p.expr(stmt.Cond, 0)
+		p.write(")")
+		p.body(stmt.If)
+
+		if stmt.Else != nil {
+			// A comment after the body stays after it, which puts the else
+			// on the next line
+			_, ok := stmt.If.(*ast.BlockStmt)
+			if p.trailing(spanOf(stmt.If).End) || !ok {
+				p.write("\n" + p.indent())
+			} else {
+				p.write(" ")
+			}
+			p.write("else")
+
+			// An else if is kept on the line of the else
+			if elseIf, ok := stmt.Else.(*ast.IfStmt); ok {
+				p.write(" ")
+				p.stmt(elseIf)
+			} else {
+				p.body(stmt.Else)
+			}
+		}
+	case *ast.WhileStmt:
+		p.label(stmt.Label)
+		p.write("while (")
+		p.expr(stmt.Cond, 0)
+		p.write(")")
+		p.body(stmt.Body)
+	case *ast.ForStmt:
+		p.label(stmt.Label)
+		p.write("for (")
+		if stmt.Init != nil {
+			p.simpleStmt(stmt.Init)
+		} else {
+			p.write(";")
+		}
+		if stmt.Cond != nil {
+			p.write(" ")
+			p.expr(stmt.Cond, 0)
+		}
+		p.write(";")
+		if stmt.Inc != nil {
+			p.write(" ")
+			p.expr(stmt.Inc, 0)
+		}
+		p.write(")")
+		p.body(stmt.Body)
+	case *ast.StructStmt:
+		p.write("struct " + stmt.Name.Literal + " ")
+		fields := make([]func(), len(stmt.Fields))
+		spans := make([]token.Span, len(stmt.Fields))
+		for n, field := range stmt.Fields {
+			field := field
+			fields[n] = func() { p.write(field.Literal) }
+			spans[n] = field.Span()
+		}
+		p.list("{", "}", fields, spans, stmt.Span)
+	case *ast.FnStmt:
+		p.write("fn " + stmt.Name.Literal)
+		p.signature(stmt.Params, stmt.ParamTypes, stmt.Result)
+		p.body(stmt.Body)
+	case *ast.ReturnStmt:
+		p.write("return")
+		if stmt.Value != nil {
+			p.write(" ")
+			p.expr(stmt.Value, 0)
+		}
+		p.write(";")
+	case *ast.ImportStmt:
+		p.write("import \"" + lexer.Quote(stmt.Path.Literal) + "\";")
+	case *ast.BreakStmt:
+		p.write("break")
+		p.branchLabel(stmt.Label)
+	case *ast.ContinueStmt:
+		p.write("continue")
+		p.branchLabel(stmt.Label)
+	default:
+		p.simpleStmt(stmt)
+	}
+}
+
+func (p *printer) label(label token.Token) {
+	if label.Literal != "" {
+		p.write(label.Literal + ": ")
+	}
+}
+
+func (p *printer) branchLabel(label token.Token) {
+	if label.Literal != "" {
+		p.write(" " + label.Literal)
+	}
+	p.write(";")
+}
+
+// simpleStmt prints a statement that can begin a for loop, a VarStmt or an
+// expression statement.
+func (p *printer) simpleStmt(stmt ast.Stmt) {
+	switch stmt := stmt.(type) {
+	case *ast.VarStmt:
+		p.write("var " + stmt.Name.Literal)
+		if stmt.Type != nil {
+			p.write(": " + stmt.Type.Name.Literal)
+		}
+		if stmt.Value != nil {
+			p.write(" = ")
+			p.expr(stmt.Value, 0)
+		}
+		p.write(";")
+	case *ast.ExprStmt:
+		// An expression statement beginning with "{" would be parsed as a
+		// block
+		if startsWithBrace(stmt.Value) {
+			p.write("(")
+			p.expr(stmt.Value, 0)
+			p.write(")")
+		} else {
+			p.expr(stmt.Value, 0)
+		}
+		p.write(";")
+	}
+}
+
+func startsWithBrace(expr ast.Expr) bool {
+	switch expr := expr.(type) {
+	case *ast.MapLiteral:
+		return true
+	case *ast.BinaryExpr:
+		return startsWithBrace(expr.Left)
+	case *ast.CallExpr:
+		return startsWithBrace(expr.Name)
+	case *ast.GetExpr:
+		return startsWithBrace(expr.Object)
+	case *ast.SetExpr:
+		return startsWithBrace(expr.Object)
+	case *ast.IndexExpr:
+		return startsWithBrace(expr.Object)
+	case *ast.SetIndexExpr:
+		return startsWithBrace(expr.Object)
+	}
+
+	return false
+}
+
+// body prints the body of a statement, on the same line if it is a block
+// and indented on the next line otherwise.
+func (p *printer) body(stmt ast.Stmt) {
+	if block, ok := stmt.(*ast.BlockStmt); ok {
+		p.write(" ")
+		p.block(block)
+		return
+	}
+
+	p.depth++
+	p.write("\n" + p.indent())
+	if stmt == nil {
+		p.write(";")
+	} else {
+		p.stmt(stmt)
+	}
+	p.depth--
+}
+
+func (p *printer) block(block *ast.BlockStmt) {
+	end := block.Span.End.Offset - 1
+	if len(block.Body) == 0 && (len(p.comments) == 0 || p.comments[0].Offset >= end) {
+		p.write("{}")
+		return
+	}
+
+	line := p.line
+
+	p.write("{\n")
+	p.depth++
+	p.stmts(block.Body, end)
+	p.depth--
+	p.write(p.indent() + "}")
+
+	p.line = line
+}
+
+func (p *printer) signature(params []token.Token, types []*ast.TypeExpr, result *ast.TypeExpr) {
+	p.write("(")
+	for n, param := range params {
+		if n > 0 {
+			p.write(", ")
+		}
+		p.write(param.Literal)
+		if n < len(types) && types[n] != nil {
+			p.write(": " + types[n].Name.Literal)
+		}
+	}
+	p.write(")")
+
+	if result != nil {
+		p.write(": " + result.Name.Literal)
+	}
+}
+
+// list prints the items of a literal, struct or call between open and
+// close. The items are printed one per line if the first one began on a new
+// line in the source or there are comments between them, and on one line
+// otherwise.
+func (p *printer) list(open, close string, items []func(), spans []token.Span, span token.Span) {
+	if len(items) == 0 {
+		p.write(open + close)
+		return
+	}
+
+	if spans[0].Start.Line == span.Start.Line && !p.commented(spans, span.End.Offset) {
+		p.write(open)
+		if open == "{" {
+			p.write(" ")
+		}
+		for n, item := range items {
+			if n > 0 {
+				p.write(", ")
+			}
+			item()
+		}
+		if close == "}" {
+			p.write(" ")
+		}
+		p.write(close)
+		return
+	}
+
+	line := p.line
+	p.line = 0
+
+	p.write(open + "\n")
+	p.depth++
+	for n, item := range items {
+		p.leading(spans[n].Start.Offset)
+		p.blank(spans[n].Start.Line)
+		p.write(p.indent())
+		item()
+		if n < len(items)-1 {
+			p.write(",")
+		}
+		p.trailing(spans[n].End)
+		p.write("\n")
+	}
+	p.leading(span.End.Offset - 1)
+	p.depth--
+	p.write(p.indent() + close)
+
+	p.line = line
+}
+
+// commented reports whether there are comments after any of the items of a
+// list ending at end, before the next item or the end.
+func (p *printer) commented(spans []token.Span, end int) bool {
+	for n, span := range spans {
+		next := end
+		if n < len(spans)-1 {
+			next = spans[n+1].Start.Offset
+		}
+
+		for _, comment := range p.comments {
+			if comment.Offset > span.End.Offset && comment.Offset < next {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Operator precedence, from loosest to tightest binding
+const (
+	precAssign = iota
+	precOr
+	precAnd
+	precBitOr
+	precBitXor
+	precBitAnd
+	precEquality
+	precComparison
+	precShift
+	precTerm
+	precFactor
+	precUnary
+	precCall
+)
+
+var binaryPrec = map[token.TokenType]int{
+	token.Or:           precOr,
+	token.And:          precAnd,
+	token.BitOr:        precBitOr,
+	token.BitXor:       precBitXor,
+	token.BitAnd:       precBitAnd,
+	token.Equal:        precEquality,
+	token.NotEqual:     precEquality,
+	token.Greater:      precComparison,
+	token.GreaterEqual: precComparison,
+	token.Less:         precComparison,
+	token.LessEqual:    precComparison,
+	token.LeftShift:    precShift,
+	token.RightShift:   precShift,
+	token.Add:          precTerm,
+	token.Sub:          precTerm,
+	token.Mul:          precFactor,
+	token.Div:          precFactor,
+	token.Mod:          precFactor,
+}
+
+func precedence(expr ast.Expr) int {
+	switch expr := expr.(type) {
+	case *ast.AssignExpr:
+		return assignPrec(expr.Op)
+	case *ast.SetExpr:
+		return assignPrec(expr.Op)
+	case *ast.SetIndexExpr:
+		return assignPrec(expr.Op)
+	case *ast.BinaryExpr:
+		return binaryPrec[expr.Op.Type]
+	case *ast.UnaryExpr:
+		return precUnary
+	}
+
+	return precCall
+}
+
+// assignPrec returns the precedence of an assignment with the operator op.
+// x++ and x-- bind like calls, as they are parsed after them.
+func assignPrec(op token.Token) int {
+	if op.Type == token.Increment || op.Type == token.Decrement {
+		return precCall
+	}
+
+	return precAssign
+}
+
+// expr prints an expression, in parentheses if it binds more loosely than
+// prec. The parser does not keep parentheses, so they are put back only
+// where they are needed.
+func (p *printer) expr(expr ast.Expr, prec int) {
+	if precedence(expr) < prec {
+		p.write("(")
+		defer p.write(")")
+	}
+
+	switch expr := expr.(type) {
+	case *ast.AssignExpr:
+		p.write(expr.Name.Literal)
+		p.assign(expr.Op, expr.Value)
+	case *ast.SetExpr:
+		p.expr(expr.Object, precCall)
+		p.write("." + expr.Name.Literal)
+		p.assign(expr.Op, expr.Value)
+	case *ast.SetIndexExpr:
+		p.expr(expr.Object, precCall)
+		p.write("[")
+		p.expr(expr.Index, 0)
+		p.write("]")
+		p.assign(expr.Op, expr.Value)
+	case *ast.BinaryExpr:
+		// Binary operators are left associative
+		prec := binaryPrec[expr.Op.Type]
+		p.expr(expr.Left, prec)
+		p.write(" " + expr.Op.Literal + " ")
+		p.expr(expr.Right, prec+1)
+	case *ast.UnaryExpr:
+		p.write(expr.Op.Literal)
+
+		// Keep "- -x" from becoming "--x"
+		if right, ok := expr.Right.(*ast.UnaryExpr); ok && right.Op.Literal == expr.Op.Literal {
+			p.write("(")
+			p.expr(right, 0)
+			p.write(")")
+		} else {
+			p.expr(expr.Right, precUnary)
+		}
+	case *ast.CallExpr:
+		p.expr(expr.Name, precCall)
+		args := make([]func(), len(expr.Args))
+		spans := make([]token.Span, len(expr.Args))
+		for n, arg := range expr.Args {
+			arg := arg
+			args[n] = func() { p.expr(arg, 0) }
+			spans[n] = spanOf(arg)
+		}
+		p.list("(", ")", args, spans, token.Span{Start: expr.Paren.Pos, End: expr.Span.End})
+	case *ast.GetExpr:
+		p.expr(expr.Object, precCall)
+		p.write("." + expr.Name.Literal)
+	case *ast.IndexExpr:
+		p.expr(expr.Object, precCall)
+		p.write("[")
+		p.expr(expr.Index, 0)
+		p.write("]")
+	case *ast.ArrayLiteral:
+		items := make([]func(), len(expr.Elements))
+		spans := make([]token.Span, len(expr.Elements))
+		for n, element := range expr.Elements {
+			element := element
+			items[n] = func() { p.expr(element, 0) }
+			spans[n] = spanOf(element)
+		}
+		p.list("[", "]", items, spans, expr.Span)
+	case *ast.MapLiteral:
+		items := make([]func(), len(expr.Keys))
+		spans := make([]token.Span, len(expr.Keys))
+		for n, key := range expr.Keys {
+			key, value := key, expr.Values[n]
+			items[n] = func() {
+				p.expr(key, 0)
+				p.write(": ")
+				p.expr(value, 0)
+			}
+			spans[n] = token.Span{Start: spanOf(key).Start, End: spanOf(value).End}
+		}
+		p.list("{", "}", items, spans, expr.Span)
+	case *ast.StructLiteral:
+		items := make([]func(), len(expr.Fields))
+		spans := make([]token.Span, len(expr.Fields))
+		for n, field := range expr.Fields {
+			field, value := field, expr.Values[n]
+			items[n] = func() {
+				p.write(field.Literal + ": ")
+				p.expr(value, 0)
+			}
+			spans[n] = token.Span{Start: field.Pos, End: spanOf(value).End}
+		}
+		p.write(expr.Name.Literal + " ")
+		p.list("{", "}", items, spans, expr.Span)
+	case *ast.FnExpr:
+		p.write("fn")
+		p.signature(expr.Params, expr.ParamTypes, expr.Result)
+		p.body(expr.Body)
+	case *ast.IdentExpr:
+		p.write(expr.Name.Literal)
+	case *ast.LiteralExpr:
+		if expr.Value.Type == token.String {
+			p.write("\"" + lexer.Quote(expr.Value.Literal) + "\"")
+		} else {
+			p.write(expr.Value.Literal)
+		}
+	case *ast.InterpolatedString:
+		p.write("\"" + lexer.Quote(expr.Literals[0].Literal))
+		for n, part := range expr.Exprs {
+			p.write("${")
+			p.expr(part, 0)
+			p.write("}" + lexer.Quote(expr.Literals[n+1].Literal))
+		}
+		p.write("\"")
+	}
+}
+
+// assign prints the operator and value of an assignment after its target.
+// The value of x++ and x-- is implied by the operator.
+func (p *printer) assign(op token.Token, value ast.Expr) {
+	if op.Type == token.Increment || op.Type == token.Decrement {
+		p.write(op.Literal)
+		return
+	}
+
+	p.write(" " + op.Literal + " ")
+	p.expr(value, precAssign)
+}
+
+func spanOf(node ast.Node) token.Span {
+	return token.Span{Start: node.Pos(), End: node.End()}
+}
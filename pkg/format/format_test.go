@@ -0,0 +1,26 @@
+package format
+
+import (
+	"testing"
+
+	"blorbo/pkg/diag"
+	"blorbo/pkg/internal/golden"
+)
+
+// TestGolden formats each file in testdata and compares the result, or the
+// errors reported if the file does not parse, with the .golden file next to
+// it. Formatted output must not change when formatted again.
+func TestGolden(t *testing.T) {
+	golden.Run(t, func(t *testing.T, file string, src string) string {
+		out, err := Source(src)
+		if err != nil {
+			diag.SetFile(err, file)
+			return golden.Errors(err)
+		}
+
+		if again, err := Source(out); err != nil || again != out {
+			t.Errorf("formatting again gave %q, %v", again, err)
+		}
+		return out
+	})
+}
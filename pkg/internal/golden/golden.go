@@ -0,0 +1,57 @@
+// Package golden runs tests that compare output with golden files.
+package golden
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// Run calls output with the path and source of each .bb file in testdata
+// and compares the result with the .golden file next to it. With -update
+// the golden files are rewritten instead.
+func Run(t *testing.T, output func(t *testing.T, file string, src string) string) {
+	t.Helper()
+
+	files, _ := filepath.Glob("testdata/*.bb")
+	for _, file := range files {
+		file := filepath.ToSlash(file)
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := output(t, file, string(src))
+
+			golden := strings.TrimSuffix(file, ".bb") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+// Errors renders err as it is printed, with a final newline, or returns ""
+// if err is nil.
+func Errors(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error() + "\n"
+}
@@ -0,0 +1,50 @@
+package interp
+
+import (
+	"blorbo/pkg/object"
+)
+
+type Environment struct {
+	values map[string]object.Value
+	parent *Environment
+}
+
+func NewEnvironment(parent *Environment) *Environment {
+	return &Environment{values: make(map[string]object.Value), parent: parent}
+}
+
+func (e *Environment) Define(name string, value object.Value) {
+	e.values[name] = value
+}
+
+func (e *Environment) Get(name string) (object.Value, bool) {
+	for env := e; env != nil; env = env.parent {
+		if value, ok := env.values[name]; ok {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+func (e *Environment) Assign(name string, value object.Value) bool {
+	for env := e; env != nil; env = env.parent {
+		if _, ok := env.values[name]; ok {
+			env.values[name] = value
+			return true
+		}
+	}
+
+	return false
+}
+
+// Copy returns a new environment with the same parent holding a copy of the
+// variables of e.
+func (e *Environment) Copy() *Environment {
+	env := NewEnvironment(e.parent)
+	for name, value := range e.values {
+		env.values[name] = value
+	}
+
+	return env
+}
@@ -0,0 +1,694 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/diag"
+	"blorbo/pkg/object"
+	"blorbo/pkg/token"
+)
+
+// returnValue unwinds the Go call stack from a ReturnStmt to the enclosing
+// function call.
+type returnValue struct {
+	value object.Value
+}
+
+func (r returnValue) Error() string {
+	return "return outside of function"
+}
+
+// branch unwinds the Go call stack from a BreakStmt or ContinueStmt to the
+// loop it applies to, which is the innermost loop unless label is set.
+type branch struct {
+	keyword token.TokenType
+	label   string
+}
+
+func (b branch) Error() string {
+	return "break or continue outside of loop"
+}
+
+// loopBranch reports whether err is a branch applying to a loop with the
+// given label, and if so whether it breaks out of the loop.
+func loopBranch(err error, label token.Token) (ok bool, brk bool) {
+	b, ok := err.(branch)
+	if !ok || (b.label != "" && b.label != label.Literal) {
+		return false, false
+	}
+
+	return true, b.keyword == token.Break
+}
+
+// maxDepth is the number of function calls that can be in progress at once.
+// It matches the frame limit of the VM, which counts the script as a frame.
+const maxDepth = 1023
+
+type Interpreter struct {
+	globals  *Environment
+	env      *Environment
+	importer object.Importer
+	out      io.Writer
+
+	// depth is the number of function calls in progress
+	depth int
+}
+
+func New(out io.Writer) *Interpreter {
+	globals := NewEnvironment(nil)
+	for _, builtin := range object.Builtins(out) {
+		globals.Define(builtin.Name, builtin)
+	}
+
+	return &Interpreter{globals: globals, env: globals, out: out}
+}
+
+// SetImporter sets the function used to load the modules imported by the
+// program. Without one, import statements fail.
+func (i *Interpreter) SetImporter(importer object.Importer) {
+	i.importer = importer
+}
+
+// SetArgs sets the arguments returned by the args builtin.
+func (i *Interpreter) SetArgs(args []string) {
+	i.globals.Define("args", object.Args(args))
+}
+
+// Globals returns the global variables of the program, including builtins.
+func (i *Interpreter) Globals() map[string]object.Value {
+	return i.globals.values
+}
+
+func (i *Interpreter) Run(program *ast.Program) error {
+	for _, stmt := range program.Stmts {
+		err := i.exec(stmt)
+		if _, ok := err.(returnValue); ok {
+			// A top-level return ends the script
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (i *Interpreter) exec(stmt ast.Stmt) error {
+	switch stmt := stmt.(type) {
+	case *ast.BlockStmt:
+		return i.execBlock(stmt.Body, NewEnvironment(i.env))
+	case *ast.IfStmt:
+		return i.execIf(stmt)
+	case *ast.WhileStmt:
+		return i.execWhile(stmt)
+	case *ast.ForStmt:
+		return i.execFor(stmt)
+	case *ast.StructStmt:
+		fields := make([]string, len(stmt.Fields))
+		for n, field := range stmt.Fields {
+			fields[n] = field.Literal
+		}
+
+		i.env.Define(stmt.Name.Literal, object.NewStruct(stmt.Name.Literal, fields))
+		return nil
+	case *ast.FnStmt:
+		fn := &Function{
+			Name:    stmt.Name.Literal,
+			Params:  stmt.Params,
+			Body:    stmt.Body,
+			Closure: i.env,
+		}
+
+		i.env.Define(stmt.Name.Literal, fn)
+		return nil
+	case *ast.VarStmt:
+		return i.execVar(stmt)
+	case *ast.ReturnStmt:
+		return i.execReturn(stmt)
+	case *ast.BreakStmt:
+		return branch{keyword: token.Break, label: stmt.Label.Literal}
+	case *ast.ContinueStmt:
+		return branch{keyword: token.Continue, label: stmt.Label.Literal}
+	case *ast.ImportStmt:
+		return i.execImport(stmt)
+	case *ast.ExprStmt:
+		_, err := i.eval(stmt.Value)
+		return err
+	default:
+		msg := fmt.Sprintf("unknown statement %T", stmt)
+		return errors.New(msg)
+	}
+}
+
+func (i *Interpreter) execBlock(stmts []ast.Stmt, env *Environment) error {
+	prev := i.env
+	i.env = env
+	defer func() { i.env = prev }()
+
+	for _, stmt := range stmts {
+		if err := i.exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// execBody runs the body of an if, while or for statement, which is a scope
+// of its own even if it is not a block.
+func (i *Interpreter) execBody(stmt ast.Stmt) error {
+	if block, ok := stmt.(*ast.BlockStmt); ok {
+		return i.execBlock(block.Body, NewEnvironment(i.env))
+	}
+
+	return i.execBlock([]ast.Stmt{stmt}, NewEnvironment(i.env))
+}
+
+func (i *Interpreter) execIf(stmt *ast.IfStmt) error {
+	cond, err := i.eval(stmt.Cond)
+	if err != nil {
+		return err
+	}
+
+	if object.Truthy(cond) {
+		return i.execBody(stmt.If)
+	} else if stmt.Else != nil {
+		return i.execBody(stmt.Else)
+	}
+
+	return nil
+}
+
+func (i *Interpreter) execWhile(stmt *ast.WhileStmt) error {
+	for {
+		cond, err := i.eval(stmt.Cond)
+		if err != nil {
+			return err
+		}
+
+		if !object.Truthy(cond) {
+			return nil
+		}
+
+		if err := i.execBody(stmt.Body); err != nil {
+			if ok, brk := loopBranch(err, stmt.Label); !ok {
+				return err
+			} else if brk {
+				return nil
+			}
+		}
+	}
+}
+
+func (i *Interpreter) execFor(stmt *ast.ForStmt) error {
+	prev := i.env
+	i.env = NewEnvironment(prev)
+	defer func() { i.env = prev }()
+
+	if stmt.Init != nil {
+		if err := i.exec(stmt.Init); err != nil {
+			return err
+		}
+	}
+
+	for {
+		if stmt.Cond != nil {
+			cond, err := i.eval(stmt.Cond)
+			if err != nil {
+				return err
+			}
+
+			if !object.Truthy(cond) {
+				return nil
+			}
+		}
+
+		// A continue still runs the increment
+		if err := i.execBody(stmt.Body); err != nil {
+			if ok, brk := loopBranch(err, stmt.Label); !ok {
+				return err
+			} else if brk {
+				return nil
+			}
+		}
+
+		// Each iteration gets fresh copies of the loop variables, so that
+		// closures created in the body capture that iteration's values
+		i.env = i.env.Copy()
+
+		if stmt.Inc != nil {
+			if _, err := i.eval(stmt.Inc); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (i *Interpreter) execVar(stmt *ast.VarStmt) error {
+	var value object.Value = object.Null{}
+	if stmt.Value != nil {
+		var err error
+		value, err = i.eval(stmt.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	i.env.Define(stmt.Name.Literal, value)
+	return nil
+}
+
+func (i *Interpreter) execReturn(stmt *ast.ReturnStmt) error {
+	var value object.Value = object.Null{}
+	if stmt.Value != nil {
+		var err error
+		value, err = i.eval(stmt.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return returnValue{value: value}
+}
+
+func (i *Interpreter) execImport(stmt *ast.ImportStmt) error {
+	if i.importer == nil {
+		return runtimeError(stmt.Keyword, "imports are not supported")
+	}
+
+	// Errors initializing the module are reported by the importer
+	m, err := i.importer(stmt.Path.Literal)
+	if err != nil {
+		return err
+	}
+
+	i.env.Define(stmt.Name.Literal, m)
+	return nil
+}
+
+func (i *Interpreter) eval(expr ast.Expr) (object.Value, error) {
+	switch expr := expr.(type) {
+	case *ast.AssignExpr:
+		return i.evalAssign(expr)
+	case *ast.BinaryExpr:
+		return i.evalBinary(expr)
+	case *ast.UnaryExpr:
+		return i.evalUnary(expr)
+	case *ast.CallExpr:
+		return i.evalCall(expr)
+	case *ast.GetExpr:
+		return i.evalGet(expr)
+	case *ast.SetExpr:
+		return i.evalSet(expr)
+	case *ast.IndexExpr:
+		return i.evalIndex(expr)
+	case *ast.SetIndexExpr:
+		return i.evalSetIndex(expr)
+	case *ast.ArrayLiteral:
+		return i.evalArrayLiteral(expr)
+	case *ast.MapLiteral:
+		return i.evalMapLiteral(expr)
+	case *ast.FnExpr:
+		return &Function{Params: expr.Params, Body: expr.Body, Closure: i.env}, nil
+	case *ast.StructLiteral:
+		return i.evalStructLiteral(expr)
+	case *ast.IdentExpr:
+		return i.evalIdent(expr)
+	case *ast.LiteralExpr:
+		return i.evalLiteral(expr)
+	case *ast.InterpolatedString:
+		return i.evalInterpolatedString(expr)
+	case nil:
+		return object.Null{}, nil
+	default:
+		msg := fmt.Sprintf("unknown expression %T", expr)
+		return nil, errors.New(msg)
+	}
+}
+
+func (i *Interpreter) evalAssign(expr *ast.AssignExpr) (object.Value, error) {
+	load := func() (object.Value, error) {
+		value, ok := i.env.Get(expr.Name.Literal)
+		if !ok {
+			return nil, undefinedError(expr.Name)
+		}
+		return value, nil
+	}
+
+	value, err := i.evalAssigned(expr.Op, expr.Value, load)
+	if err != nil {
+		return nil, err
+	}
+
+	if !i.env.Assign(expr.Name.Literal, value) {
+		return nil, undefinedError(expr.Name)
+	}
+
+	return value, nil
+}
+
+// evalAssigned evaluates the value stored by an assignment with the operator
+// op. A compound operator is applied to the current value of the target,
+// which load reads before value is evaluated, and to value.
+func (i *Interpreter) evalAssigned(op token.Token, value ast.Expr, load func() (object.Value, error)) (object.Value, error) {
+	binary, ok := token.Compound(op.Type)
+	if !ok {
+		return i.eval(value)
+	}
+
+	left, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	right, err := i.eval(value)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := object.Binary(binary, left, right)
+	if err != nil {
+		return nil, runtimeError(op, "%s", err)
+	}
+
+	return result, nil
+}
+
+func (i *Interpreter) evalBinary(expr *ast.BinaryExpr) (object.Value, error) {
+	left, err := i.eval(expr.Left)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logical operators short-circuit and yield the deciding operand
+	switch expr.Op.Type {
+	case token.And:
+		if !object.Truthy(left) {
+			return left, nil
+		}
+		return i.eval(expr.Right)
+	case token.Or:
+		if object.Truthy(left) {
+			return left, nil
+		}
+		return i.eval(expr.Right)
+	}
+
+	right, err := i.eval(expr.Right)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := object.Binary(expr.Op.Type, left, right)
+	if err != nil {
+		return nil, runtimeError(expr.Op, "%s", err)
+	}
+
+	return value, nil
+}
+
+func (i *Interpreter) evalUnary(expr *ast.UnaryExpr) (object.Value, error) {
+	right, err := i.eval(expr.Right)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := object.Unary(expr.Op.Type, right)
+	if err != nil {
+		return nil, runtimeError(expr.Op, "%s", err)
+	}
+
+	return value, nil
+}
+
+func (i *Interpreter) evalCall(expr *ast.CallExpr) (object.Value, error) {
+	callee, err := i.eval(expr.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []object.Value
+	for _, arg := range expr.Args {
+		value, err := i.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		args = append(args, value)
+	}
+
+	switch callee := callee.(type) {
+	case *Function:
+		return i.callFunction(callee, args, expr.Paren)
+	case *object.Builtin:
+		value, err := callee.Fn(args)
+		if err != nil {
+			return nil, runtimeError(expr.Paren, "%s", err)
+		}
+		return value, nil
+	default:
+		return nil, runtimeError(expr.Paren, "can only call functions")
+	}
+}
+
+func (i *Interpreter) callFunction(fn *Function, args []object.Value, paren token.Token) (object.Value, error) {
+	params := fn.Params
+	if len(args) != len(params) {
+		return nil, runtimeError(paren, "expected %d arguments but got %d", len(params), len(args))
+	}
+
+	if i.depth == maxDepth {
+		return nil, runtimeError(paren, "stack overflow")
+	}
+
+	env := NewEnvironment(fn.Closure)
+	for n, param := range params {
+		env.Define(param.Literal, args[n])
+	}
+
+	i.depth++
+	err := i.execBlock([]ast.Stmt{fn.Body}, env)
+	i.depth--
+	if ret, ok := err.(returnValue); ok {
+		return ret.value, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return object.Null{}, nil
+}
+
+func (i *Interpreter) evalGet(expr *ast.GetExpr) (object.Value, error) {
+	obj, err := i.eval(expr.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := object.GetField(obj, expr.Name.Literal)
+	if err != nil {
+		return nil, runtimeError(expr.Name, "%s", err)
+	}
+
+	return value, nil
+}
+
+func (i *Interpreter) evalSet(expr *ast.SetExpr) (object.Value, error) {
+	obj, err := i.eval(expr.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	load := func() (object.Value, error) {
+		value, err := object.GetField(obj, expr.Name.Literal)
+		if err != nil {
+			return nil, runtimeError(expr.Name, "%s", err)
+		}
+		return value, nil
+	}
+
+	value, err := i.evalAssigned(expr.Op, expr.Value, load)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := object.SetField(obj, expr.Name.Literal, value); err != nil {
+		return nil, runtimeError(expr.Name, "%s", err)
+	}
+
+	return value, nil
+}
+
+func (i *Interpreter) evalIndex(expr *ast.IndexExpr) (object.Value, error) {
+	obj, err := i.eval(expr.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := i.eval(expr.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := object.Index(obj, index)
+	if err != nil {
+		return nil, runtimeError(expr.Bracket, "%s", err)
+	}
+
+	return value, nil
+}
+
+func (i *Interpreter) evalSetIndex(expr *ast.SetIndexExpr) (object.Value, error) {
+	obj, err := i.eval(expr.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := i.eval(expr.Index)
+	if err != nil {
+		return nil, err
+	}
+
+	load := func() (object.Value, error) {
+		value, err := object.Index(obj, index)
+		if err != nil {
+			return nil, runtimeError(expr.Bracket, "%s", err)
+		}
+		return value, nil
+	}
+
+	value, err := i.evalAssigned(expr.Op, expr.Value, load)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := object.SetIndex(obj, index, value); err != nil {
+		return nil, runtimeError(expr.Bracket, "%s", err)
+	}
+
+	return value, nil
+}
+
+func (i *Interpreter) evalArrayLiteral(expr *ast.ArrayLiteral) (object.Value, error) {
+	elements := make([]object.Value, len(expr.Elements))
+	for n, element := range expr.Elements {
+		value, err := i.eval(element)
+		if err != nil {
+			return nil, err
+		}
+
+		elements[n] = value
+	}
+
+	return &object.Array{Elements: elements}, nil
+}
+
+func (i *Interpreter) evalMapLiteral(expr *ast.MapLiteral) (object.Value, error) {
+	m := object.NewMap()
+	for n, key := range expr.Keys {
+		k, err := i.eval(key)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := i.eval(expr.Values[n])
+		if err != nil {
+			return nil, err
+		}
+
+		if err := m.Set(k, v); err != nil {
+			return nil, runtimeError(expr.Brace, "%s", err)
+		}
+	}
+
+	return m, nil
+}
+
+func (i *Interpreter) evalStructLiteral(expr *ast.StructLiteral) (object.Value, error) {
+	callee, ok := i.env.Get(expr.Name.Literal)
+	if !ok {
+		return nil, undefinedError(expr.Name)
+	}
+
+	fields := make([]string, len(expr.Fields))
+	values := make([]object.Value, len(expr.Values))
+	for n, field := range expr.Fields {
+		value, err := i.eval(expr.Values[n])
+		if err != nil {
+			return nil, err
+		}
+
+		fields[n] = field.Literal
+		values[n] = value
+	}
+
+	instance, err := object.Instantiate(callee, fields, values)
+	if err != nil {
+		return nil, runtimeError(expr.Name, "%s", err)
+	}
+
+	return instance, nil
+}
+
+func (i *Interpreter) evalIdent(expr *ast.IdentExpr) (object.Value, error) {
+	value, ok := i.env.Get(expr.Name.Literal)
+	if !ok {
+		return nil, undefinedError(expr.Name)
+	}
+
+	return value, nil
+}
+
+func (i *Interpreter) evalLiteral(expr *ast.LiteralExpr) (object.Value, error) {
+	switch expr.Value.Type {
+	case token.Int:
+		value, err := token.ParseInt(expr.Value.Literal)
+		if err != nil {
+			return nil, diag.New(diag.NumberOverflow, expr.Value.Span(), "%s", err)
+		}
+		return object.Integer(value), nil
+	case token.Float:
+		value, err := token.ParseFloat(expr.Value.Literal)
+		if err != nil {
+			return nil, diag.New(diag.NumberOverflow, expr.Value.Span(), "%s", err)
+		}
+		return object.Number(value), nil
+	case token.String:
+		return object.String(expr.Value.Literal), nil
+	case token.True:
+		return object.Bool(true), nil
+	case token.False:
+		return object.Bool(false), nil
+	default:
+		return object.Null{}, nil
+	}
+}
+
+func (i *Interpreter) evalInterpolatedString(expr *ast.InterpolatedString) (object.Value, error) {
+	var sb strings.Builder
+	sb.WriteString(expr.Literals[0].Literal)
+
+	for n, part := range expr.Exprs {
+		value, err := i.eval(part)
+		if err != nil {
+			return nil, err
+		}
+
+		sb.WriteString(value.String())
+		sb.WriteString(expr.Literals[n+1].Literal)
+	}
+
+	return object.String(sb.String()), nil
+}
+
+func undefinedError(name token.Token) error {
+	return runtimeError(name, "undefined variable '%s'", name.Literal)
+}
+
+// runtimeError reports an error in the code at tok.
+func runtimeError(tok token.Token, format string, args ...interface{}) error {
+	return diag.New(diag.Runtime, tok.Span(), format, args...)
+}
@@ -0,0 +1,32 @@
+package interp
+
+import (
+	"fmt"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/object"
+	"blorbo/pkg/token"
+)
+
+// Function is a function declared by a FnStmt or FnExpr, closing over the
+// environment it was declared in. Anonymous functions have an empty Name.
+type Function struct {
+	Name    string
+	Params  []token.Token
+	Body    ast.Stmt
+	Closure *Environment
+}
+
+func (*Function) Type() object.Type { return object.FunctionType }
+
+func (f *Function) String() string {
+	if f.Name == "" {
+		return "<fn>"
+	}
+
+	return fmt.Sprintf("<fn %s>", f.Name)
+}
+
+func (f *Function) NumParams() int {
+	return len(f.Params)
+}
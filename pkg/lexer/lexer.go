@@ -1,26 +1,44 @@
 package lexer
 
 import (
-	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 
+	"blorbo/pkg/diag"
 	"blorbo/pkg/token"
 )
 
 var keywords = map[string]token.TokenType{
-	"var":    token.Var,
-	"return": token.Return,
-	"fn":     token.Fn,
-	"struct": token.Struct,
-	"for":    token.For,
-	"while":  token.While,
-	"if":     token.If,
-	"else":   token.Else,
-	"null":   token.Null,
-	"true":   token.True,
-	"false":  token.False,
-	"and":    token.And,
-	"or":     token.Or,
+	"var":      token.Var,
+	"return":   token.Return,
+	"fn":       token.Fn,
+	"struct":   token.Struct,
+	"for":      token.For,
+	"while":    token.While,
+	"if":       token.If,
+	"else":     token.Else,
+	"null":     token.Null,
+	"true":     token.True,
+	"false":    token.False,
+	"and":      token.And,
+	"or":       token.Or,
+	"import":   token.Import,
+	"break":    token.Break,
+	"continue": token.Continue,
+}
+
+// Keywords returns the keywords of the language in alphabetical order.
+func Keywords() []string {
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
 }
 
 func isWhitespace(c byte) bool {
@@ -39,178 +57,358 @@ type Lexer struct {
 	src  string
 	pos  int
 	line int
+
+	// lineStart is the offset of the first byte of the current line, and
+	// start the position of the token being read
+	lineStart int
+	start     token.Pos
+
+	// interps holds, for each interpolation in a string being read, the
+	// number of braces left open in it, so that the "}" ending it is told
+	// apart from one closing a block or map
+	interps []int
+
+	comments bool
+	diags    diag.List
 }
 
 func New(src string) *Lexer {
 	return &Lexer{src: src, line: 1}
 }
 
+// KeepComments makes Scan return Comment tokens, which it drops by default.
+// A Comment token holds the whole comment, including the leading "//".
+func (l *Lexer) KeepComments() {
+	l.comments = true
+}
+
+// Scan returns the tokens of the source, reporting every lexical error found
+// as a diag.List. Source code that is not a valid token is returned as an
+// Illegal token, so the tokens can still be parsed. The last token is always
+// Eof.
 func (l *Lexer) Scan() ([]token.Token, error) {
 	var tokens []token.Token
-	var err error
 
-	for l.pos < len(l.src) {
-		tok, _err := l.nextToken()
-		if _err != nil {
-			fmt.Println(_err)
-			err = _err
-		}
+	for {
+		tok := l.nextToken()
 
-		// Ignore comments
-		if tok.Type != token.Comment {
+		if tok.Type != token.Comment || l.comments {
 			tokens = append(tokens, tok)
 		}
-	}
 
-	return tokens, err
+		// The tokens always end with Eof
+		if tok.Type == token.Eof {
+			return tokens, l.diags.Err()
+		}
+	}
 }
 
-func (l *Lexer) nextToken() (token.Token, error) {
-	c := l.readChar()
-
+func (l *Lexer) nextToken() token.Token {
 	// Consume whitespace
-	for ; isWhitespace(c); c = l.readChar() {
-		if c == '\n' {
-			l.line++
-		}
+	for isWhitespace(l.peekChar()) {
+		l.readChar()
+	}
+
+	l.start = l.position()
+	if l.atEnd() {
+		return l.token(token.Eof, "")
 	}
 
+	c := l.readChar()
+
 	var tok token.Token
 
 	switch c {
-	case 0:
-		tok = token.New(token.Eof, "", l.line)
 	case '(':
-		tok = token.New(token.LeftParen, "(", l.line)
+		tok = l.token(token.LeftParen, "(")
 	case ')':
-		tok = token.New(token.RightParen, ")", l.line)
+		tok = l.token(token.RightParen, ")")
 	case '{':
-		tok = token.New(token.LeftBrace, "{", l.line)
+		if n := len(l.interps); n > 0 {
+			l.interps[n-1]++
+		}
+		tok = l.token(token.LeftBrace, "{")
 	case '}':
-		tok = token.New(token.RightBrace, "}", l.line)
+		n := len(l.interps)
+		if n > 0 && l.interps[n-1] == 0 {
+			l.interps = l.interps[:n-1]
+			tok = l.readString(token.StringMiddle, token.StringTail)
+			break
+		}
+
+		if n > 0 {
+			l.interps[n-1]--
+		}
+		tok = l.token(token.RightBrace, "}")
+	case '[':
+		tok = l.token(token.LeftBracket, "[")
+	case ']':
+		tok = l.token(token.RightBracket, "]")
 	case '.':
-		tok = token.New(token.Dot, ".", l.line)
+		tok = l.token(token.Dot, ".")
 	case ',':
-		tok = token.New(token.Comma, ",", l.line)
+		tok = l.token(token.Comma, ",")
+	case ':':
+		tok = l.token(token.Colon, ":")
 	case ';':
-		tok = token.New(token.Semicolon, ";", l.line)
+		tok = l.token(token.Semicolon, ";")
 	case '*':
-		tok = token.New(token.Mul, "*", l.line)
+		tok = l.operator(token.Mul, token.MulAssign)
 	case '/':
 		if l.peekChar() == '/' {
-			tok = token.New(token.Comment, l.readComment(), l.line)
+			tok = l.token(token.Comment, l.readComment())
 		} else {
-			tok = token.New(token.Div, "/", l.line)
+			tok = l.operator(token.Div, token.DivAssign)
 		}
 	case '%':
-		tok = token.New(token.Mod, "%", l.line)
+		tok = l.operator(token.Mod, token.ModAssign)
 	case '+':
-		tok = token.New(token.Add, "+", l.line)
+		if l.peekChar() == '+' {
+			l.readChar()
+			tok = l.token(token.Increment, "++")
+		} else {
+			tok = l.operator(token.Add, token.AddAssign)
+		}
 	case '-':
-		tok = token.New(token.Sub, "-", l.line)
+		if l.peekChar() == '-' {
+			l.readChar()
+			tok = l.token(token.Decrement, "--")
+		} else {
+			tok = l.operator(token.Sub, token.SubAssign)
+		}
 	case '=':
 		if l.peekChar() == '=' {
 			l.readChar()
-			tok = token.New(token.Equal, "==", l.line)
+			tok = l.token(token.Equal, "==")
 		} else {
-			tok = token.New(token.Assign, "=", l.line)
+			tok = l.token(token.Assign, "=")
 		}
 	case '!':
 		if l.peekChar() == '=' {
 			l.readChar()
-			tok = token.New(token.NotEqual, "!=", l.line)
+			tok = l.token(token.NotEqual, "!=")
 		} else {
-			tok = token.New(token.Not, "!", l.line)
+			tok = l.token(token.Not, "!")
 		}
 	case '>':
 		if l.peekChar() == '=' {
 			l.readChar()
-			tok = token.New(token.GreaterEqual, ">=", l.line)
+			tok = l.token(token.GreaterEqual, ">=")
 		} else if l.peekChar() == '>' {
 			l.readChar()
-			tok = token.New(token.RightShift, ">>", l.line)
+			tok = l.operator(token.RightShift, token.RightShiftAssign)
 		} else {
-			tok = token.New(token.Greater, ">", l.line)
+			tok = l.token(token.Greater, ">")
 		}
 	case '<':
 		if l.peekChar() == '=' {
 			l.readChar()
-			tok = token.New(token.LessEqual, "<=", l.line)
+			tok = l.token(token.LessEqual, "<=")
 		} else if l.peekChar() == '<' {
 			l.readChar()
-			tok = token.New(token.LeftShift, "<<", l.line)
+			tok = l.operator(token.LeftShift, token.LeftShiftAssign)
 		} else {
-			tok = token.New(token.Less, "<", l.line)
+			tok = l.token(token.Less, "<")
 		}
 	case '&':
-		tok = token.New(token.BitAnd, "&", l.line)
+		tok = l.operator(token.BitAnd, token.BitAndAssign)
 	case '|':
-		tok = token.New(token.BitOr, "|", l.line)
+		tok = l.operator(token.BitOr, token.BitOrAssign)
 	case '^':
-		tok = token.New(token.BitXor, "^", l.line)
+		tok = l.operator(token.BitXor, token.BitXorAssign)
 	case '~':
-		tok = token.New(token.BitNot, "~", l.line)
+		tok = l.token(token.BitNot, "~")
 	case '"':
-		str, err := l.readString()
-		if err != nil {
-			return tok, err
-		}
-		tok = token.New(token.String, str, l.line)
+		tok = l.readString(token.StringHead, token.String)
 	default:
 		if isAlpha(c) {
 			key := l.readIdent()
 			val, ok := keywords[key]
 
 			if ok {
-				tok = token.New(val, key, l.line)
+				tok = l.token(val, key)
 			} else {
-				tok = token.New(token.Ident, key, l.line)
+				tok = l.token(token.Ident, key)
 			}
 		} else if isDigit(c) {
-			tok = token.New(token.Number, l.readNumber(), l.line)
+			tok = l.readNumber()
 		} else {
-			msg := fmt.Sprintf("unexpected character '%c' on line %d", c, l.line)
-			return tok, errors.New(msg)
+			tok = l.readIllegal()
 		}
 	}
 
-	return tok, nil
+	return tok
+}
+
+// operator returns a token of type assign if the operator read so far is
+// followed by "=", as in "+=", and of type op otherwise.
+func (l *Lexer) operator(op token.TokenType, assign token.TokenType) token.Token {
+	if l.peekChar() == '=' {
+		l.readChar()
+		return l.token(assign, l.src[l.start.Offset:l.pos])
+	}
+
+	return l.token(op, l.src[l.start.Offset:l.pos])
+}
+
+// readIllegal reads a character that cannot begin a token. A character of
+// several bytes is read whole, so that it is reported once.
+func (l *Lexer) readIllegal() token.Token {
+	r, size := utf8.DecodeRuneInString(l.src[l.start.Offset:])
+	for n := 1; n < size; n++ {
+		l.readChar()
+	}
+
+	if r == utf8.RuneError && size == 1 {
+		l.errorf(diag.UnexpectedChar, "invalid UTF-8 byte 0x%02x", l.src[l.start.Offset])
+	} else {
+		l.errorf(diag.UnexpectedChar, "unexpected character %q", r)
+	}
+	return l.token(token.Illegal, l.src[l.start.Offset:l.pos])
+}
+
+// token returns a token of the source from l.start to the current position.
+func (l *Lexer) token(ty token.TokenType, literal string) token.Token {
+	return token.Token{Type: ty, Literal: literal, Pos: l.start, End: l.position()}
+}
+
+func (l *Lexer) position() token.Pos {
+	return token.Pos{Offset: l.pos, Line: l.line, Column: l.pos - l.lineStart + 1}
+}
+
+// errorf reports an error in the source from l.start to the current position.
+func (l *Lexer) errorf(code diag.Code, format string, args ...interface{}) {
+	span := token.Span{Start: l.start, End: l.position()}
+	l.diags = append(l.diags, diag.New(code, span, format, args...))
 }
 
 func (l *Lexer) readChar() byte {
-	if l.pos >= len(l.src) {
+	if l.atEnd() {
 		return 0
 	}
 
 	c := l.src[l.pos]
 	l.pos++
+
+	if c == '\n' {
+		l.line++
+		l.lineStart = l.pos
+	}
+
 	return c
 }
 
+func (l *Lexer) atEnd() bool {
+	return l.pos >= len(l.src)
+}
+
 func (l *Lexer) peekChar() byte {
-	if l.pos >= len(l.src) {
+	if l.atEnd() {
 		return 0
 	}
 
 	return l.src[l.pos]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an Int or Float literal. Digits may be separated by
+// single underscores, and an Int may be written in hex, octal or binary with
+// a 0x, 0o or 0b prefix.
+func (l *Lexer) readNumber() token.Token {
+	// The first digit is read again, as part of the digits it begins
 	start := l.pos - 1
+	l.pos = start
+
+	ty := token.Int
+	wellFormed := true
 
-	for isDigit(l.peekChar()) {
+	if digit := digitsOf(l.src[start:]); digit != nil {
 		l.readChar()
+		l.readChar()
+		wellFormed = l.readDigits(digit)
+	} else {
+		wellFormed = l.readDigits(isDigit)
+
+		if l.peekChar() == '.' {
+			l.readChar()
+			ty = token.Float
+
+			// A number cannot end in "."
+			wellFormed = l.readDigits(isDigit) && wellFormed
+		}
+
+		if c := l.peekChar(); c == 'e' || c == 'E' {
+			l.readChar()
+			ty = token.Float
+
+			if c := l.peekChar(); c == '+' || c == '-' {
+				l.readChar()
+			}
+			wellFormed = l.readDigits(isDigit) && wellFormed
+		}
 	}
 
-	if l.src[l.pos] == '.' {
+	// Letters, digits and dots directly after a number are taken to be part
+	// of it, so "12ab" or "1.2.3" is reported as one malformed number
+	for c := l.peekChar(); isAlpha(c) || isDigit(c) || c == '.'; c = l.peekChar() {
 		l.readChar()
+		wellFormed = false
+	}
+
+	literal := l.src[start:l.pos]
+	if !wellFormed {
+		l.errorf(diag.MalformedNumber, "malformed number '%s'", literal)
+		return l.token(token.Illegal, literal)
+	}
+
+	var err error
+	if ty == token.Float {
+		_, err = token.ParseFloat(literal)
+	} else {
+		_, err = token.ParseInt(literal)
+	}
+	if err != nil {
+		l.errorf(diag.NumberOverflow, "%s", err)
+		return l.token(token.Illegal, literal)
+	}
+
+	return l.token(ty, literal)
+}
+
+// digitsOf returns the function telling the digits of a number starting with
+// a base prefix, or nil if src does not start with one.
+func digitsOf(src string) func(byte) bool {
+	if len(src) < 2 || src[0] != '0' {
+		return nil
+	}
+
+	switch src[1] {
+	case 'x', 'X':
+		return isHexDigit
+	case 'o', 'O':
+		return func(c byte) bool { return c >= '0' && c <= '7' }
+	case 'b', 'B':
+		return func(c byte) bool { return c == '0' || c == '1' }
+	}
+
+	return nil
+}
+
+// readDigits reads digits separated by single underscores, reporting whether
+// there was at least one and every underscore was between two.
+func (l *Lexer) readDigits(digit func(byte) bool) bool {
+	wellFormed := digit(l.peekChar())
 
-		for isDigit(l.peekChar()) {
+	for {
+		c := l.peekChar()
+		if c == '_' {
 			l.readChar()
+			wellFormed = wellFormed && digit(l.peekChar())
+		} else if digit(c) {
+			l.readChar()
+		} else {
+			return wellFormed
 		}
 	}
-
-	return l.src[start:l.pos]
 }
 
 func (l *Lexer) readIdent() string {
@@ -223,35 +421,165 @@ func (l *Lexer) readIdent() string {
 	return l.src[start:l.pos]
 }
 
-func (l *Lexer) readString() (string, error) {
-	start := l.pos
-	line := l.line
+// readString reads the rest of a string or of a part of one, decoding its
+// escapes. It returns a token of type open if the part ends by starting an
+// interpolation and of type closed if it ends the string.
+func (l *Lexer) readString(open token.TokenType, closed token.TokenType) token.Token {
+	var sb strings.Builder
+
+	for !l.atEnd() {
+		switch c := l.readChar(); {
+		case c == '"':
+			return l.token(closed, sb.String())
+		case c == '$' && l.peekChar() == '{':
+			l.readChar()
+			l.interps = append(l.interps, 0)
+			return l.token(open, sb.String())
+		case c == '\\':
+			start := token.Pos{Offset: l.pos - 1, Line: l.line, Column: l.pos - l.lineStart}
+			l.readEscape(&sb, start)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	l.errorf(diag.UnterminatedString, "unterminated string")
+	return l.token(token.Illegal, l.src[l.start.Offset:l.pos])
+}
+
+// readEscape decodes the escape sequence after a "\" into sb, reporting an
+// error if it is not valid.
+func (l *Lexer) readEscape(sb *strings.Builder, start token.Pos) {
+	c := l.readChar()
+	switch c {
+	case 'n':
+		sb.WriteByte('\n')
+	case 't':
+		sb.WriteByte('\t')
+	case 'r':
+		sb.WriteByte('\r')
+	case '0':
+		sb.WriteByte(0)
+	case '\\', '"', '$':
+		sb.WriteByte(c)
+	case 'x', 'u', 'U':
+		digits := 2
+		if c == 'u' {
+			digits = 4
+		} else if c == 'U' {
+			digits = 8
+		}
+
+		value := 0
+		for n := 0; n < digits; n++ {
+			d, ok := hexDigit(l.peekChar())
+			if !ok {
+				l.escapeErrorf(start, "invalid escape '%s', expected %d hex digits", l.src[start.Offset:l.pos], digits)
+				return
+			}
 
-	for l.peekChar() != '"' && l.peekChar() != 0 {
-		if l.peekChar() == '\n' {
-			l.line++
+			l.readChar()
+			value = value*16 + d
 		}
 
-		l.readChar()
+		if c == 'x' {
+			sb.WriteByte(byte(value))
+		} else if utf8.ValidRune(rune(value)) {
+			sb.WriteRune(rune(value))
+		} else {
+			l.escapeErrorf(start, "invalid escape '%s', not a unicode code point", l.src[start.Offset:l.pos])
+		}
+	default:
+		if c == 0 && l.atEnd() {
+			// Reported as an unterminated string
+			return
+		}
+
+		if c < ' ' {
+			l.escapeErrorf(start, "invalid escape, '\\' followed by %q", c)
+			return
+		}
+
+		// The rest of a character of several bytes is part of the escape
+		_, size := utf8.DecodeRuneInString(l.src[l.pos-1:])
+		for n := 1; n < size; n++ {
+			l.readChar()
+		}
+
+		l.escapeErrorf(start, "invalid escape '%s'", l.src[start.Offset:l.pos])
 	}
+}
+
+// escapeErrorf reports an error in the escape sequence from start to the
+// current position.
+func (l *Lexer) escapeErrorf(start token.Pos, format string, args ...interface{}) {
+	span := token.Span{Start: start, End: l.position()}
+	l.diags = append(l.diags, diag.New(diag.InvalidEscape, span, format, args...))
+}
+
+// Quote returns s as the text of a string literal, without the quotes,
+// escaping what would end the string or start an interpolation in it, control
+// characters and bytes that are not valid UTF-8.
+func Quote(s string) string {
+	var sb strings.Builder
+
+	for n := 0; n < len(s); {
+		r, size := utf8.DecodeRuneInString(s[n:])
+
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&sb, "\\x%02x", s[n])
+		case r == '"' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r == '$' && strings.HasPrefix(s[n+1:], "{"):
+			sb.WriteString("\\$")
+		case r == '\n':
+			sb.WriteString("\\n")
+		case r == '\t':
+			sb.WriteString("\\t")
+		case r == '\r':
+			sb.WriteString("\\r")
+		case r == 0:
+			sb.WriteString("\\0")
+		case r < utf8.RuneSelf && !unicode.IsPrint(r):
+			fmt.Fprintf(&sb, "\\x%02x", r)
+		case !unicode.IsPrint(r) && r <= 0xffff:
+			fmt.Fprintf(&sb, "\\u%04x", r)
+		case !unicode.IsPrint(r):
+			fmt.Fprintf(&sb, "\\U%08x", r)
+		default:
+			sb.WriteRune(r)
+		}
 
-	if l.peekChar() == 0 {
-		msg := fmt.Sprintf("unterminated string on line %d", line)
-		return "", errors.New(msg)
+		n += size
 	}
 
-	end := l.pos
-	l.readChar()
+	return sb.String()
+}
 
-	return l.src[start:end], nil
+func isHexDigit(c byte) bool {
+	_, ok := hexDigit(c)
+	return ok
 }
 
-func (l *Lexer) readComment() string {
-	start := l.pos
+func hexDigit(c byte) (int, bool) {
+	switch {
+	case isDigit(c):
+		return int(c - '0'), true
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10, true
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10, true
+	}
 
-	for l.peekChar() != '\n' && l.peekChar() != 0 {
+	return 0, false
+}
+
+func (l *Lexer) readComment() string {
+	for !l.atEnd() && l.peekChar() != '\n' {
 		l.readChar()
 	}
 
-	return l.src[start:l.pos]
+	return l.src[l.start.Offset:l.pos]
 }
@@ -0,0 +1,68 @@
+package lexer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"blorbo/pkg/token"
+)
+
+var seeds = []string{
+	"",
+	"x;",
+	"1",
+	"1.",
+	"1.5.2",
+	"12ab",
+	"0x1F_ab 0b1_0 0o7 1e-3 1_000.5E+2",
+	"0x 0b2 1__0 1_ 1e 0x_1 99999999999999999999 1e999",
+	"\"abc",
+	"\"abc\"",
+	"\"a\\n\\\"\\x4g\\u00e9\\q\\",
+	"\"a${b}c${ {d: \"${e}\"} }f\"",
+	"\"a${b",
+	"}\"${}",
+	"// comment",
+	"a >>= b << c",
+	"a += b-- - -c ++d <<= e %= f",
+	"@#$",
+	"var x = {1: [2, 3]};\x00",
+}
+
+func FuzzScan(f *testing.F) {
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	examples, _ := filepath.Glob("../../examples/*.bb")
+	for _, example := range examples {
+		src, err := os.ReadFile(example)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		tokens, _ := New(src).Scan()
+
+		if len(tokens) == 0 || tokens[len(tokens)-1].Type != token.Eof {
+			t.Fatalf("tokens of %q do not end with Eof", src)
+		}
+
+		for n, tok := range tokens[:len(tokens)-1] {
+			if tok.Type == token.Eof {
+				t.Fatalf("token %d of %q is Eof before the end", n, src)
+			}
+		}
+
+		offset := 0
+		for _, tok := range tokens {
+			if tok.Offset < offset || tok.End.Offset < tok.Offset || tok.End.Offset > len(src) {
+				t.Fatalf("token %v of %q has span %v", tok, src, tok.Span())
+			}
+			offset = tok.End.Offset
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package lsp
+
+import (
+	"errors"
+	"sort"
+	"unicode/utf8"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/diag"
+	"blorbo/pkg/lexer"
+	"blorbo/pkg/parser"
+	"blorbo/pkg/resolver"
+	"blorbo/pkg/token"
+	"blorbo/pkg/types"
+)
+
+// document is an open text document and the result of checking it.
+type document struct {
+	text string
+
+	// lines holds the offset of the start of each line
+	lines []int
+
+	// tokens holds the tokens of the text including comments
+	tokens   []token.Token
+	program  *ast.Program
+	resolved *resolver.Result
+
+	// valid reports whether the text parsed without errors
+	valid bool
+	diags diag.List
+}
+
+// check lexes, parses and checks text as the module loader does, but keeps
+// going past errors so that there is a program to answer requests about.
+func check(text string) *document {
+	doc := &document{text: text, lines: []int{0}}
+	for n := 0; n < len(text); n++ {
+		if text[n] == '\n' {
+			doc.lines = append(doc.lines, n+1)
+		}
+	}
+
+	l := lexer.New(text)
+	l.KeepComments()
+	tokens, lexErr := l.Scan()
+	doc.tokens = tokens
+
+	var code []token.Token
+	for _, tok := range tokens {
+		if tok.Type != token.Comment {
+			code = append(code, tok)
+		}
+	}
+
+	program, parseErr := parser.New(code).Parse()
+	doc.program = program
+
+	resolved, resolveErr := resolver.New().Resolve(program)
+	doc.resolved = resolved
+
+	// As with the loader, the later passes are only reported on once the
+	// earlier ones succeed
+	err := diag.Join(lexErr, parseErr)
+	if err == nil {
+		doc.valid = true
+
+		err = resolveErr
+		if err == nil {
+			err = types.New(resolved).Check(program)
+		}
+	}
+
+	var list diag.List
+	var d *diag.Diagnostic
+	if errors.As(err, &list) {
+		doc.diags = list
+	} else if errors.As(err, &d) {
+		doc.diags = diag.List{d}
+	}
+
+	return doc
+}
+
+// position converts a position in the text to an LSP position.
+func (d *document) position(pos token.Pos) Position {
+	offset := min(max(pos.Offset, 0), len(d.text))
+	line := sort.Search(len(d.lines), func(n int) bool { return d.lines[n] > offset }) - 1
+
+	character := 0
+	for _, r := range d.text[d.lines[line]:offset] {
+		character += utf16Len(r)
+	}
+
+	return Position{Line: line, Character: character}
+}
+
+func (d *document) span(span token.Span) Range {
+	return Range{Start: d.position(span.Start), End: d.position(span.End)}
+}
+
+// offset converts an LSP position to an offset in the text.
+func (d *document) offset(pos Position) int {
+	if pos.Line < 0 {
+		return 0
+	}
+	if pos.Line >= len(d.lines) {
+		return len(d.text)
+	}
+
+	offset := d.lines[pos.Line]
+	character := 0
+	for offset < len(d.text) && d.text[offset] != '\n' && character < pos.Character {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		character += utf16Len(r)
+		offset += size
+	}
+
+	return offset
+}
+
+// utf16Len returns the number of UTF-16 code units encoding r.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+
+	return 1
+}
+
+// whole returns the range from the start to the end of the text.
+func (d *document) whole() Range {
+	end := token.Pos{Offset: len(d.text)}
+	return Range{End: d.position(end)}
+}
+
+func (d *document) diagnostics() []Diagnostic {
+	diagnostics := make([]Diagnostic, 0, len(d.diags))
+	for _, diagnostic := range d.diags {
+		severity := severityError
+		if diagnostic.Severity == diag.Warning {
+			severity = severityWarning
+		}
+
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    d.span(diagnostic.Span),
+			Severity: severity,
+			Code:     string(diagnostic.Code),
+			Source:   "blorbo",
+			Message:  diagnostic.Message,
+		})
+	}
+
+	return diagnostics
+}
+
+// contains reports whether offset is in a name token or just after it.
+func contains(name token.Token, offset int) bool {
+	return name.Offset <= offset && offset <= name.End.Offset
+}
+
+// declAt returns the declaration of the name at offset, and the token of
+// the name itself.
+func (d *document) declAt(offset int) (*resolver.Decl, token.Token, bool) {
+	for _, scope := range d.resolved.Scopes {
+		for _, decl := range scope.Decls {
+			if decl.Kind != resolver.Builtin && contains(decl.Name, offset) {
+				return decl, decl.Name, true
+			}
+		}
+	}
+
+	for expr, binding := range d.resolved.Bindings {
+		var name token.Token
+		switch expr := expr.(type) {
+		case *ast.IdentExpr:
+			name = expr.Name
+		case *ast.AssignExpr:
+			name = expr.Name
+		case *ast.StructLiteral:
+			name = expr.Name
+		}
+
+		if contains(name, offset) {
+			return binding.Decl, name, true
+		}
+	}
+
+	return nil, token.Token{}, false
+}
+
+// visible returns the declarations that can be used at offset, with inner
+// declarations hiding outer ones of the same name.
+func (d *document) visible(offset int) []*resolver.Decl {
+	var decls []*resolver.Decl
+	seen := make(map[string]bool)
+
+	// Scopes begin in order, so the scopes containing offset are nested in
+	// the ones before them
+	for n := len(d.resolved.Scopes) - 1; n >= 0; n-- {
+		scope := d.resolved.Scopes[n]
+		if !scope.Contains(offset) {
+			continue
+		}
+
+		for _, decl := range scope.Decls {
+			// Globals can be used before their declaration inside functions
+			if n > 0 && decl.Name.Offset >= offset {
+				continue
+			}
+
+			if !seen[decl.Name.Literal] {
+				seen[decl.Name.Literal] = true
+				decls = append(decls, decl)
+			}
+		}
+	}
+
+	return decls
+}
@@ -0,0 +1,119 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+)
+
+// JSON-RPC error codes
+const (
+	parseError     = -32700
+	invalidRequest = -32600
+	methodNotFound = -32601
+	invalidParams  = -32602
+	internalError  = -32603
+
+	serverNotInitialized = -32002
+)
+
+// message is a JSON-RPC request or notification. Notifications have no ID.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  interface{}      `json:"result"`
+}
+
+type errorResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Error   *Error           `json:"error"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// Error is a JSON-RPC error, returned by a handler to answer a request with
+// a specific error code.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// conn reads and writes JSON-RPC messages framed with a Content-Length
+// header, as the Language Server Protocol does over stdio.
+type conn struct {
+	in  *textproto.Reader
+	out io.Writer
+}
+
+func newConn(in io.Reader, out io.Writer) *conn {
+	return &conn{in: textproto.NewReader(bufio.NewReader(in)), out: out}
+}
+
+// read reads the content of the next message. It returns io.EOF when the
+// input ends between messages.
+func (c *conn) read() ([]byte, error) {
+	header, err := c.in.ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("invalid Content-Length '%s'", header.Get("Content-Length"))
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(c.in.R, content); err != nil {
+		return nil, err
+	}
+
+	return content, nil
+}
+
+func (c *conn) write(msg interface{}) error {
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(c.out, "Content-Length: %d\r\n\r\n", len(content)); err != nil {
+		return err
+	}
+
+	_, err = c.out.Write(content)
+	return err
+}
+
+func (c *conn) reply(id *json.RawMessage, result interface{}) error {
+	return c.write(&response{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+func (c *conn) replyError(id *json.RawMessage, err *Error) error {
+	return c.write(&errorResponse{JSONRPC: "2.0", ID: id, Error: err})
+}
+
+func (c *conn) notify(method string, params interface{}) error {
+	return c.write(&notification{JSONRPC: "2.0", Method: method, Params: params})
+}
@@ -0,0 +1,484 @@
+package lsp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/format"
+	"blorbo/pkg/lexer"
+	"blorbo/pkg/resolver"
+	"blorbo/pkg/token"
+)
+
+// Server is a Language Server Protocol server for Blorbo, speaking JSON-RPC
+// over a pair of streams.
+type Server struct {
+	conn    *conn
+	version string
+	docs    map[string]*document
+
+	initialized bool
+	shutdown    bool
+}
+
+func NewServer(in io.Reader, out io.Writer, version string) *Server {
+	return &Server{
+		conn:    newConn(in, out),
+		version: version,
+		docs:    make(map[string]*document),
+	}
+}
+
+// ErrNoShutdown is returned by Run when the client exits or the input ends
+// without a shutdown request.
+var ErrNoShutdown = errors.New("lsp: exit without shutdown")
+
+// Run serves requests until the client asks the server to exit.
+func (s *Server) Run() error {
+	for {
+		content, err := s.conn.read()
+		if err == io.EOF {
+			return ErrNoShutdown
+		}
+		if err != nil {
+			return err
+		}
+
+		var msg message
+		if err := json.Unmarshal(content, &msg); err != nil {
+			s.conn.replyError(nil, &Error{Code: parseError, Message: err.Error()})
+			continue
+		}
+
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+
+		if err := s.handle(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+type handler func(s *Server, params json.RawMessage) (interface{}, error)
+
+var handlers = map[string]handler{
+	"initialize":                  (*Server).initialize,
+	"initialized":                 (*Server).ignore,
+	"shutdown":                    (*Server).shutdownServer,
+	"textDocument/didOpen":        (*Server).didOpen,
+	"textDocument/didChange":      (*Server).didChange,
+	"textDocument/didClose":       (*Server).didClose,
+	"textDocument/documentSymbol": (*Server).documentSymbol,
+	"textDocument/definition":     (*Server).definition,
+	"textDocument/hover":          (*Server).hover,
+	"textDocument/completion":     (*Server).completion,
+	"textDocument/formatting":     (*Server).formatting,
+}
+
+// handle handles a message, replying to it if it is a request. Only errors
+// writing to the client are returned.
+func (s *Server) handle(msg *message) error {
+	h, ok := handlers[msg.Method]
+
+	// Notifications are never replied to, even with an error
+	if msg.ID == nil {
+		if ok && (s.initialized || msg.Method == "initialized") {
+			s.call(h, msg.Params)
+		}
+		return nil
+	}
+
+	if !ok {
+		return s.conn.replyError(msg.ID, &Error{Code: methodNotFound, Message: fmt.Sprintf("unknown method '%s'", msg.Method)})
+	}
+
+	if !s.initialized && msg.Method != "initialize" {
+		return s.conn.replyError(msg.ID, &Error{Code: serverNotInitialized, Message: "server not initialized"})
+	}
+
+	if s.shutdown {
+		return s.conn.replyError(msg.ID, &Error{Code: invalidRequest, Message: "server is shutting down"})
+	}
+
+	result, err := s.call(h, msg.Params)
+	if err != nil {
+		var rpcErr *Error
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &Error{Code: internalError, Message: err.Error()}
+		}
+		return s.conn.replyError(msg.ID, rpcErr)
+	}
+
+	return s.conn.reply(msg.ID, result)
+}
+
+// call calls a handler, returning an internal error if it panics so that one
+// bad request does not stop the server.
+func (s *Server) call(h handler, params json.RawMessage) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = nil
+			err = &Error{Code: internalError, Message: fmt.Sprintf("internal error: %v", r)}
+		}
+	}()
+
+	return h(s, params)
+}
+
+// decode decodes the params of a message into v.
+func decode(params json.RawMessage, v interface{}) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &Error{Code: invalidParams, Message: err.Error()}
+	}
+
+	return nil
+}
+
+func (s *Server) ignore(params json.RawMessage) (interface{}, error) {
+	return nil, nil
+}
+
+func (s *Server) initialize(params json.RawMessage) (interface{}, error) {
+	s.initialized = true
+
+	return &InitializeResult{
+		Capabilities: ServerCapabilities{
+			TextDocumentSync:           syncFull,
+			DocumentSymbolProvider:     true,
+			DefinitionProvider:         true,
+			HoverProvider:              true,
+			CompletionProvider:         &CompletionOptions{},
+			DocumentFormattingProvider: true,
+		},
+		ServerInfo: ServerInfo{Name: "blorbo", Version: s.version},
+	}, nil
+}
+
+func (s *Server) shutdownServer(params json.RawMessage) (interface{}, error) {
+	s.shutdown = true
+	return nil, nil
+}
+
+func (s *Server) didOpen(params json.RawMessage) (interface{}, error) {
+	var p DidOpenTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	return nil, s.update(p.TextDocument.URI, p.TextDocument.Text)
+}
+
+func (s *Server) didChange(params json.RawMessage) (interface{}, error) {
+	var p DidChangeTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	// With full sync the last change holds the whole text
+	if len(p.ContentChanges) == 0 {
+		return nil, nil
+	}
+	text := p.ContentChanges[len(p.ContentChanges)-1].Text
+
+	return nil, s.update(p.TextDocument.URI, text)
+}
+
+func (s *Server) didClose(params json.RawMessage) (interface{}, error) {
+	var p DidCloseTextDocumentParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	delete(s.docs, p.TextDocument.URI)
+	return nil, s.conn.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+		URI:         p.TextDocument.URI,
+		Diagnostics: []Diagnostic{},
+	})
+}
+
+// update checks the new text of a document and publishes its diagnostics.
+func (s *Server) update(uri string, text string) error {
+	doc := check(text)
+	s.docs[uri] = doc
+
+	return s.conn.notify("textDocument/publishDiagnostics", &PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: doc.diagnostics(),
+	})
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil, &Error{Code: invalidParams, Message: fmt.Sprintf("document '%s' is not open", uri)}
+	}
+
+	return doc, nil
+}
+
+// documentAt returns the document and offset of a position.
+func (s *Server) documentAt(params json.RawMessage) (*document, int, error) {
+	var p TextDocumentPositionParams
+	if err := decode(params, &p); err != nil {
+		return nil, 0, err
+	}
+
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return doc, doc.offset(p.Position), nil
+}
+
+func (s *Server) documentSymbol(params json.RawMessage) (interface{}, error) {
+	var p DocumentSymbolParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	return doc.symbols(doc.program.Stmts), nil
+}
+
+// symbols returns the symbols declared by stmts, looking into the bodies of
+// statements but not of functions, whose symbols are their children.
+func (d *document) symbols(stmts []ast.Stmt) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+
+	for _, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.BlockStmt:
+			symbols = append(symbols, d.symbols(stmt.Body)...)
+		case *ast.IfStmt:
+			symbols = append(symbols, d.symbols([]ast.Stmt{stmt.If, stmt.Else})...)
+		case *ast.WhileStmt:
+			symbols = append(symbols, d.symbols([]ast.Stmt{stmt.Body})...)
+		case *ast.ForStmt:
+			symbols = append(symbols, d.symbols([]ast.Stmt{stmt.Init, stmt.Body})...)
+		case *ast.FnStmt:
+			symbols = append(symbols, DocumentSymbol{
+				Name:           stmt.Name.Literal,
+				Detail:         signature("fn", stmt.Params, stmt.ParamTypes, stmt.Result),
+				Kind:           symbolFunction,
+				Range:          d.span(stmt.Span),
+				SelectionRange: d.span(stmt.Name.Span()),
+				Children:       d.symbols([]ast.Stmt{stmt.Body}),
+			})
+		case *ast.VarStmt:
+			symbol := DocumentSymbol{
+				Name:           stmt.Name.Literal,
+				Kind:           symbolVariable,
+				Range:          d.span(stmt.Span),
+				SelectionRange: d.span(stmt.Name.Span()),
+			}
+			if stmt.Type != nil {
+				symbol.Detail = stmt.Type.Name.Literal
+			}
+
+			if fn, ok := stmt.Value.(*ast.FnExpr); ok {
+				symbol.Kind = symbolFunction
+				symbol.Detail = signature("fn", fn.Params, fn.ParamTypes, fn.Result)
+				symbol.Children = d.symbols([]ast.Stmt{fn.Body})
+			}
+
+			symbols = append(symbols, symbol)
+		case *ast.StructStmt:
+			symbol := DocumentSymbol{
+				Name:           stmt.Name.Literal,
+				Kind:           symbolStruct,
+				Range:          d.span(stmt.Span),
+				SelectionRange: d.span(stmt.Name.Span()),
+			}
+			for _, field := range stmt.Fields {
+				symbol.Children = append(symbol.Children, DocumentSymbol{
+					Name:           field.Literal,
+					Kind:           symbolField,
+					Range:          d.span(field.Span()),
+					SelectionRange: d.span(field.Span()),
+				})
+			}
+
+			symbols = append(symbols, symbol)
+		case *ast.ImportStmt:
+			symbols = append(symbols, DocumentSymbol{
+				Name:           stmt.Name.Literal,
+				Detail:         stmt.Path.Literal,
+				Kind:           symbolModule,
+				Range:          d.span(stmt.Span),
+				SelectionRange: d.span(stmt.Path.Span()),
+			})
+		}
+	}
+
+	return symbols
+}
+
+func (s *Server) definition(params json.RawMessage) (interface{}, error) {
+	var p TextDocumentPositionParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	doc, offset, err := s.documentAt(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Builtins are not declared in the source
+	decl, _, ok := doc.declAt(offset)
+	if !ok || decl.Kind == resolver.Builtin {
+		return nil, nil
+	}
+
+	return &Location{URI: p.TextDocument.URI, Range: doc.span(decl.Name.Span())}, nil
+}
+
+func (s *Server) hover(params json.RawMessage) (interface{}, error) {
+	doc, offset, err := s.documentAt(params)
+	if err != nil {
+		return nil, err
+	}
+
+	decl, name, ok := doc.declAt(offset)
+	if !ok {
+		return nil, nil
+	}
+
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: "```blorbo\n" + describe(decl) + "\n```"},
+		Range:    doc.span(name.Span()),
+	}, nil
+}
+
+// describe returns the declaration of a name as it would be written in the
+// source, without the bodies of functions.
+func describe(decl *resolver.Decl) string {
+	name := decl.Name.Literal
+
+	if decl.Kind == resolver.Param {
+		var types []*ast.TypeExpr
+		switch node := decl.Node.(type) {
+		case *ast.FnStmt:
+			types = node.ParamTypes
+		case *ast.FnExpr:
+			types = node.ParamTypes
+		}
+
+		if decl.Slot < len(types) && types[decl.Slot] != nil {
+			return "param " + name + ": " + types[decl.Slot].Name.Literal
+		}
+		return "param " + name
+	}
+
+	switch node := decl.Node.(type) {
+	case *ast.FnStmt:
+		return signature("fn "+name, node.Params, node.ParamTypes, node.Result)
+	case *ast.VarStmt:
+		if fn, ok := node.Value.(*ast.FnExpr); ok {
+			return "var " + name + " = " + signature("fn", fn.Params, fn.ParamTypes, fn.Result)
+		}
+		if node.Type != nil {
+			return "var " + name + ": " + node.Type.Name.Literal
+		}
+		return "var " + name
+	case *ast.StructStmt:
+		fields := make([]string, len(node.Fields))
+		for n, field := range node.Fields {
+			fields[n] = field.Literal
+		}
+		return "struct " + name + " { " + strings.Join(fields, ", ") + " }"
+	case *ast.ImportStmt:
+		return "import \"" + node.Path.Literal + "\""
+	}
+
+	return "builtin fn " + name
+}
+
+func signature(prefix string, params []token.Token, types []*ast.TypeExpr, result *ast.TypeExpr) string {
+	var sb strings.Builder
+	sb.WriteString(prefix + "(")
+	for n, param := range params {
+		if n > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(param.Literal)
+		if n < len(types) && types[n] != nil {
+			sb.WriteString(": " + types[n].Name.Literal)
+		}
+	}
+	sb.WriteString(")")
+
+	if result != nil {
+		sb.WriteString(": " + result.Name.Literal)
+	}
+
+	return sb.String()
+}
+
+func (s *Server) completion(params json.RawMessage) (interface{}, error) {
+	doc, offset, err := s.documentAt(params)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []CompletionItem{}
+	for _, keyword := range lexer.Keywords() {
+		items = append(items, CompletionItem{Label: keyword, Kind: completionKeyword})
+	}
+
+	kinds := map[resolver.Kind]int{
+		resolver.Var:     completionVariable,
+		resolver.Param:   completionVariable,
+		resolver.Fn:      completionFunction,
+		resolver.Builtin: completionFunction,
+		resolver.Struct:  completionStruct,
+		resolver.Import:  completionModule,
+	}
+
+	for _, decl := range doc.visible(offset) {
+		items = append(items, CompletionItem{
+			Label:  decl.Name.Literal,
+			Kind:   kinds[decl.Kind],
+			Detail: describe(decl),
+		})
+	}
+
+	return items, nil
+}
+
+func (s *Server) formatting(params json.RawMessage) (interface{}, error) {
+	var p DocumentFormattingParams
+	if err := decode(params, &p); err != nil {
+		return nil, err
+	}
+
+	doc, err := s.document(p.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	// Code with syntax errors is left alone
+	if !doc.valid {
+		return nil, nil
+	}
+
+	text := format.Program(doc.program, doc.tokens)
+	if text == doc.text {
+		return []TextEdit{}, nil
+	}
+
+	return []TextEdit{{Range: doc.whole(), NewText: text}}, nil
+}
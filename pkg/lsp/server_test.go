@@ -0,0 +1,188 @@
+package lsp
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// client drives a Server over a pair of pipes as an editor would.
+type client struct {
+	t    *testing.T
+	conn *conn
+	id   int
+}
+
+// start runs a server and returns a client connected to it, together with
+// a channel receiving the error Run returns.
+func start(t *testing.T) (*client, <-chan error) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	t.Cleanup(func() {
+		inW.Close()
+		outR.Close()
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer(inR, outW, "1.2.3").Run()
+	}()
+
+	return &client{t: t, conn: newConn(outR, inW)}, done
+}
+
+func (c *client) notify(method string, params interface{}) {
+	c.t.Helper()
+	if err := c.conn.notify(method, params); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// request sends a request and decodes the result of the reply into result.
+// It returns the error the server replied with instead, if any.
+func (c *client) request(method string, params interface{}, result interface{}) *Error {
+	c.t.Helper()
+
+	content, err := json.Marshal(params)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	c.id++
+	id := json.RawMessage(strconv.Itoa(c.id))
+	if err := c.conn.write(&message{JSONRPC: "2.0", ID: &id, Method: method, Params: content}); err != nil {
+		c.t.Fatal(err)
+	}
+
+	var reply struct {
+		ID     json.RawMessage
+		Result json.RawMessage
+		Error  *Error
+	}
+	c.read(&reply)
+
+	if string(reply.ID) != string(id) {
+		c.t.Fatalf("%s: got reply to %s, want %s", method, reply.ID, id)
+	}
+	if reply.Error != nil {
+		return reply.Error
+	}
+	if err := json.Unmarshal(reply.Result, result); err != nil {
+		c.t.Fatalf("%s: %v", method, err)
+	}
+	return nil
+}
+
+// read decodes the next message from the server into v.
+func (c *client) read(v interface{}) {
+	c.t.Helper()
+
+	content, err := c.conn.read()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	c, done := start(t)
+
+	// Requests other than initialize are refused until the server is
+	// initialized
+	var symbols []DocumentSymbol
+	err := c.request("textDocument/documentSymbol", &DocumentSymbolParams{}, &symbols)
+	if err == nil || err.Code != serverNotInitialized {
+		t.Errorf("request before initialize gave error %v, want code %d", err, serverNotInitialized)
+	}
+
+	var init InitializeResult
+	if err := c.request("initialize", struct{}{}, &init); err != nil {
+		t.Fatal(err)
+	}
+	if init.ServerInfo != (ServerInfo{Name: "blorbo", Version: "1.2.3"}) {
+		t.Errorf("server info is %+v", init.ServerInfo)
+	}
+	if !init.Capabilities.DefinitionProvider || !init.Capabilities.HoverProvider {
+		t.Errorf("capabilities are %+v", init.Capabilities)
+	}
+	c.notify("initialized", struct{}{})
+
+	// The emoji takes 4 bytes, and 2 UTF-16 code units
+	const uri = "file:///main.bb"
+	const text = "var s = \"😀\"; println(s + m);\n" +
+		"fn add(a: int, b: int): int { return a + b; }\n" +
+		"println(add(1, 2));\n"
+	c.notify("textDocument/didOpen", &DidOpenTextDocumentParams{
+		TextDocument: TextDocumentItem{URI: uri, LanguageID: "blorbo", Version: 1, Text: text},
+	})
+
+	var published struct {
+		Method string
+		Params PublishDiagnosticsParams
+	}
+	c.read(&published)
+	want := PublishDiagnosticsParams{
+		URI: uri,
+		Diagnostics: []Diagnostic{{
+			Range:    Range{Start: Position{0, 26}, End: Position{0, 27}},
+			Severity: severityError,
+			Code:     "undefined",
+			Source:   "blorbo",
+			Message:  "undefined variable 'm'",
+		}},
+	}
+	if published.Method != "textDocument/publishDiagnostics" || !reflect.DeepEqual(published.Params, want) {
+		t.Errorf("didOpen published %s %+v, want %+v", published.Method, published.Params, want)
+	}
+
+	// The s after the emoji
+	at := &TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: uri},
+		Position:     Position{0, 22},
+	}
+	var location Location
+	if err := c.request("textDocument/definition", at, &location); err != nil {
+		t.Fatal(err)
+	}
+	if want := (Location{URI: uri, Range: Range{Position{0, 4}, Position{0, 5}}}); location != want {
+		t.Errorf("definition is %+v, want %+v", location, want)
+	}
+
+	var hover Hover
+	if err := c.request("textDocument/hover", at, &hover); err != nil {
+		t.Fatal(err)
+	}
+	if want := "```blorbo\nvar s\n```"; hover.Contents.Value != want {
+		t.Errorf("hover is %q, want %q", hover.Contents.Value, want)
+	}
+	if want := (Range{Position{0, 22}, Position{0, 23}}); hover.Range != want {
+		t.Errorf("hover range is %+v, want %+v", hover.Range, want)
+	}
+
+	at.Position = Position{2, 9}
+	if err := c.request("textDocument/hover", at, &hover); err != nil {
+		t.Fatal(err)
+	}
+	if want := "```blorbo\nfn add(a: int, b: int): int\n```"; hover.Contents.Value != want {
+		t.Errorf("hover is %q, want %q", hover.Contents.Value, want)
+	}
+
+	at.TextDocument.URI = "file:///closed.bb"
+	err = c.request("textDocument/definition", at, &location)
+	if err == nil || err.Code != invalidParams || err.Message != "document 'file:///closed.bb' is not open" {
+		t.Errorf("definition in an unopened document gave error %v", err)
+	}
+
+	var result interface{}
+	if err := c.request("shutdown", nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	c.notify("exit", nil)
+	if err := <-done; err != nil {
+		t.Errorf("Run returned %v after shutdown", err)
+	}
+}
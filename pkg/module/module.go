@@ -0,0 +1,308 @@
+package module
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/diag"
+	"blorbo/pkg/lexer"
+	"blorbo/pkg/object"
+	"blorbo/pkg/parser"
+	"blorbo/pkg/resolver"
+	"blorbo/pkg/types"
+)
+
+// Module is a parsed and checked source file.
+type Module struct {
+	Name    string
+	Path    string
+	Program *ast.Program
+
+	// Resolved holds the declarations and bindings of the names in Program
+	Resolved *resolver.Result
+
+	// imports maps the path of each import statement in the program to the
+	// module it refers to
+	imports map[string]*Module
+}
+
+// Exec runs the program of a module with an execution engine, using importer
+// to initialize the modules it imports, and returns the module's globals. The
+// globals must be those the engine reads and writes, not a copy, as they are
+// the members of the module.
+type Exec func(program *ast.Program, importer object.Importer) (map[string]object.Value, error)
+
+// Loader loads modules and the modules they import, parsing and checking
+// each file once and initializing each module once.
+type Loader struct {
+	// SearchPath lists the directories searched for an imported file that is
+	// not found relative to the importing file
+	SearchPath []string
+
+	modules map[string]*Module
+	values  map[*Module]*object.Module
+
+	// loading holds the paths of the files being loaded, each importing the
+	// next, to detect import cycles
+	loading []string
+}
+
+func NewLoader(searchPath []string) *Loader {
+	return &Loader{
+		SearchPath: searchPath,
+		modules:    make(map[string]*Module),
+		values:     make(map[*Module]*object.Module),
+	}
+}
+
+// Load loads the file at path and every module it imports.
+func (l *Loader) Load(path string) (*Module, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.load(abs)
+}
+
+// LoadSource loads src as a module that is not read from a file, resolving
+// its imports relative to dir.
+func (l *Loader) LoadSource(src string, dir string) (*Module, error) {
+	return l.parse("", dir, src)
+}
+
+// LoadProgram loads a program that is already parsed, resolving its imports
+// relative to dir. The names in globals, such as those declared by earlier
+// input to an interactive session, are declared before those of the program.
+func (l *Loader) LoadProgram(program *ast.Program, dir string, globals []*resolver.Decl) (*Module, error) {
+	return l.check("", dir, program, globals)
+}
+
+func (l *Loader) load(path string) (*Module, error) {
+	if m, ok := l.modules[path]; ok {
+		return m, nil
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l.loading = append(l.loading, path)
+	m, err := l.parse(path, filepath.Dir(path), string(src))
+	l.loading = l.loading[:len(l.loading)-1]
+	if err != nil {
+		return nil, err
+	}
+
+	l.modules[path] = m
+	return m, nil
+}
+
+func (l *Loader) parse(path string, dir string, src string) (*Module, error) {
+	// The tokens are parsed even if there are lexical errors, so that syntax
+	// errors are reported with them
+	tokens, lexErr := lexer.New(src).Scan()
+	program, err := parser.New(tokens).Parse()
+	if err := diag.Join(lexErr, err); err != nil {
+		return nil, l.wrap(path, err)
+	}
+
+	return l.check(path, dir, program, nil)
+}
+
+func (l *Loader) check(path string, dir string, program *ast.Program, globals []*resolver.Decl) (*Module, error) {
+	wrap := func(err error) error { return l.wrap(path, err) }
+
+	r := resolver.New()
+	r.SetGlobals(globals)
+	resolved, err := r.Resolve(program)
+	if err != nil {
+		return nil, wrap(err)
+	}
+
+	if err := types.New(resolved).Check(program); err != nil {
+		return nil, wrap(err)
+	}
+
+	m := &Module{
+		Name:     strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Path:     path,
+		Program:  program,
+		Resolved: resolved,
+		imports:  make(map[string]*Module),
+	}
+
+	// The resolver only allows imports at the top level
+	for _, stmt := range program.Stmts {
+		stmt, ok := stmt.(*ast.ImportStmt)
+		if !ok {
+			continue
+		}
+
+		file, ok := l.find(stmt.Path.Literal, dir)
+		if !ok {
+			span := stmt.Path.Span()
+			d := diag.New(diag.ModuleNotFound, span, "cannot find module '%s'", stmt.Path.Literal)
+			return nil, wrap(d)
+		}
+
+		for n, loading := range l.loading {
+			if loading != file {
+				continue
+			}
+
+			cycle := make([]string, 0, len(l.loading)-n+1)
+			for _, path := range l.loading[n:] {
+				cycle = append(cycle, display(path))
+			}
+			cycle = append(cycle, display(file))
+
+			span := stmt.Path.Span()
+			d := diag.New(diag.ImportCycle, span, "import cycle %s", strings.Join(cycle, " -> "))
+			return nil, wrap(d)
+		}
+
+		dep, err := l.load(file)
+		if err != nil {
+			return nil, err
+		}
+
+		m.imports[stmt.Path.Literal] = dep
+	}
+
+	return m, nil
+}
+
+// wrap prepares an error in the file at path to be returned. Diagnostics
+// are given the name of the file, and other errors in imported files are
+// prefixed with it.
+func (l *Loader) wrap(path string, err error) error {
+	if isDiag(err) {
+		if path != "" {
+			diag.SetFile(err, display(path))
+		}
+		return err
+	}
+
+	if len(l.loading) < 2 {
+		return err
+	}
+	return wrapError(path, err)
+}
+
+// isDiag reports whether err is a *diag.Diagnostic or a diag.List, which
+// name the file they are in.
+func isDiag(err error) bool {
+	var d *diag.Diagnostic
+	var list diag.List
+	return errors.As(err, &d) || errors.As(err, &list)
+}
+
+// find returns the absolute path of the file imported with path from a file
+// in dir.
+func (l *Loader) find(path string, dir string) (string, bool) {
+	path = filepath.FromSlash(path)
+	if filepath.IsAbs(path) {
+		return path, isFile(path)
+	}
+
+	for _, base := range append([]string{dir}, l.SearchPath...) {
+		file, err := filepath.Abs(filepath.Join(base, path))
+		if err == nil && isFile(file) {
+			return file, true
+		}
+	}
+
+	return "", false
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// Run initializes a module with exec. The modules it imports are initialized
+// when their import statements run, unless they already have been.
+func (l *Loader) Run(m *Module, exec Exec) error {
+	_, err := l.init(m, exec)
+	return err
+}
+
+func (l *Loader) init(m *Module, exec Exec) (*object.Module, error) {
+	if value, ok := l.values[m]; ok {
+		return value, nil
+	}
+
+	importer := func(path string) (*object.Module, error) {
+		dep := m.imports[path]
+
+		value, err := l.init(dep, exec)
+		if err != nil && !isDiag(err) {
+			return nil, wrapError(dep.Path, err)
+		}
+
+		return value, err
+	}
+
+	globals, err := exec(m.Program, importer)
+	if err != nil {
+		if m.Path != "" {
+			diag.SetFile(err, display(m.Path))
+		}
+		return nil, err
+	}
+
+	value := &object.Module{Name: m.Name, Globals: globals}
+	l.values[m] = value
+	return value, nil
+}
+
+// Error is an error in an imported file.
+type Error struct {
+	Path string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	lines := strings.Split(e.Err.Error(), "\n")
+	for n, line := range lines {
+		lines[n] = fmt.Sprintf("%s: %s", display(e.Path), line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// wrapError attributes err to the file at path, unless it already belongs to
+// a file imported from there.
+func wrapError(path string, err error) error {
+	var moduleErr *Error
+	if errors.As(err, &moduleErr) {
+		return err
+	}
+
+	return &Error{Path: path, Err: err}
+}
+
+// display returns a path relative to the working directory if possible.
+func display(path string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+
+	return rel
+}
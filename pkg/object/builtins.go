@@ -0,0 +1,189 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Builtins returns the builtin functions available to every script, writing
+// any output to out.
+func Builtins(out io.Writer) []*Builtin {
+	return []*Builtin{
+		{Name: "println", Fn: printlnBuiltin(out)},
+		{Name: "len", Fn: lenBuiltin},
+		{Name: "push", Fn: pushBuiltin},
+		{Name: "pop", Fn: popBuiltin},
+		{Name: "keys", Fn: keysBuiltin},
+		{Name: "values", Fn: valuesBuiltin},
+		{Name: "has", Fn: hasBuiltin},
+		{Name: "delete", Fn: deleteBuiltin},
+		Args(nil),
+	}
+}
+
+// Args returns the args builtin for a script given args on the command line.
+// Every engine has one, returning no arguments unless it is replaced.
+func Args(args []string) *Builtin {
+	return &Builtin{Name: "args", Fn: argsBuiltin(args)}
+}
+
+// args() returns a new array of the arguments given to the script.
+func argsBuiltin(args []string) BuiltinFn {
+	return func(values []Value) (Value, error) {
+		if err := checkArgs("args", values, 0); err != nil {
+			return nil, err
+		}
+
+		elements := make([]Value, len(args))
+		for n, arg := range args {
+			elements[n] = String(arg)
+		}
+
+		return &Array{Elements: elements}, nil
+	}
+}
+
+func printlnBuiltin(out io.Writer) BuiltinFn {
+	return func(args []Value) (Value, error) {
+		parts := make([]string, len(args))
+		for n, arg := range args {
+			parts[n] = arg.String()
+		}
+
+		fmt.Fprintln(out, strings.Join(parts, " "))
+		return Null{}, nil
+	}
+}
+
+// len(value) returns the number of elements of an array, entries of a map or
+// bytes of a string.
+func lenBuiltin(args []Value) (Value, error) {
+	if err := checkArgs("len", args, 1); err != nil {
+		return nil, err
+	}
+
+	switch arg := args[0].(type) {
+	case *Array:
+		return Integer(len(arg.Elements)), nil
+	case *Map:
+		return Integer(arg.Len()), nil
+	case String:
+		return Integer(len(arg)), nil
+	}
+
+	return nil, argError("len", args[0])
+}
+
+// push(array, value) appends value to the end of array.
+func pushBuiltin(args []Value) (Value, error) {
+	if err := checkArgs("push", args, 2); err != nil {
+		return nil, err
+	}
+
+	array, ok := args[0].(*Array)
+	if !ok {
+		return nil, argError("push", args[0])
+	}
+
+	array.Elements = append(array.Elements, args[1])
+	return Null{}, nil
+}
+
+// pop(array) removes and returns the last element of array.
+func popBuiltin(args []Value) (Value, error) {
+	if err := checkArgs("pop", args, 1); err != nil {
+		return nil, err
+	}
+
+	array, ok := args[0].(*Array)
+	if !ok {
+		return nil, argError("pop", args[0])
+	}
+
+	if len(array.Elements) == 0 {
+		return nil, errors.New("pop from empty array")
+	}
+
+	last := array.Elements[len(array.Elements)-1]
+	array.Elements = array.Elements[:len(array.Elements)-1]
+	return last, nil
+}
+
+// keys(map) returns an array of the keys of map in insertion order.
+func keysBuiltin(args []Value) (Value, error) {
+	m, err := mapArg("keys", args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Array{Elements: m.Keys()}, nil
+}
+
+// values(map) returns an array of the values of map in insertion order.
+func valuesBuiltin(args []Value) (Value, error) {
+	m, err := mapArg("values", args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Array{Elements: m.Values()}, nil
+}
+
+// has(map, key) reports whether key is present in map.
+func hasBuiltin(args []Value) (Value, error) {
+	m, err := mapArg("has", args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	_, ok, err := m.Get(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return Bool(ok), nil
+}
+
+// delete(map, key) removes key from map, reporting whether it was present.
+func deleteBuiltin(args []Value) (Value, error) {
+	m, err := mapArg("delete", args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := m.Delete(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	return Bool(ok), nil
+}
+
+func mapArg(name string, args []Value, want int) (*Map, error) {
+	if err := checkArgs(name, args, want); err != nil {
+		return nil, err
+	}
+
+	m, ok := args[0].(*Map)
+	if !ok {
+		return nil, argError(name, args[0])
+	}
+
+	return m, nil
+}
+
+func checkArgs(name string, args []Value, want int) error {
+	if len(args) != want {
+		msg := fmt.Sprintf("%s expects %d arguments but got %d", name, want, len(args))
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+func argError(name string, arg Value) error {
+	msg := fmt.Sprintf("invalid argument to %s: %s", name, arg.Type())
+	return errors.New(msg)
+}
@@ -0,0 +1,375 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type Type string
+
+const (
+	NullType     Type = "null"
+	BoolType     Type = "bool"
+	IntegerType  Type = "int"
+	NumberType   Type = "float"
+	StringType   Type = "string"
+	FunctionType Type = "function"
+	BuiltinType  Type = "builtin"
+	StructType   Type = "struct"
+	ArrayType    Type = "array"
+	MapType      Type = "map"
+	ModuleType   Type = "module"
+)
+
+// Value is a runtime value shared by every execution engine.
+type Value interface {
+	Type() Type
+	String() string
+}
+
+// Null is the type of the null literal and of variables declared without an
+// initializer.
+type Null struct{}
+
+func (Null) Type() Type     { return NullType }
+func (Null) String() string { return "null" }
+
+type Bool bool
+
+func (Bool) Type() Type       { return BoolType }
+func (b Bool) String() string { return strconv.FormatBool(bool(b)) }
+
+// Integer is a signed 64-bit integer. Arithmetic on integers wraps around on
+// overflow.
+type Integer int64
+
+func (Integer) Type() Type       { return IntegerType }
+func (i Integer) String() string { return strconv.FormatInt(int64(i), 10) }
+
+// Number is an IEEE 754 double precision float.
+type Number float64
+
+func (Number) Type() Type { return NumberType }
+
+// String always includes a fraction or exponent, so that floats can be told
+// apart from integers when printed.
+func (n Number) String() string {
+	s := strconv.FormatFloat(float64(n), 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+
+	return s
+}
+
+type String string
+
+func (String) Type() Type       { return StringType }
+func (s String) String() string { return string(s) }
+
+// Array is a growable sequence of values. Arrays are references: copies of an
+// array value share the same elements.
+type Array struct {
+	Elements []Value
+}
+
+func (*Array) Type() Type { return ArrayType }
+
+func (a *Array) String() string {
+	return a.inspect(make(map[Value]bool))
+}
+
+func (a *Array) inspect(seen map[Value]bool) string {
+	if seen[a] {
+		return "[...]"
+	}
+	seen[a] = true
+	defer delete(seen, a)
+
+	parts := make([]string, len(a.Elements))
+	for n, element := range a.Elements {
+		parts[n] = inspect(element, seen)
+	}
+
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// Map is an associative container from keys to values. Keys must be null,
+// bools, ints, floats or strings. Iterating a map visits its keys in the
+// order they were first inserted.
+type Map struct {
+	keys   []Value
+	values []Value
+	index  map[Value]int
+}
+
+func NewMap() *Map {
+	return &Map{index: make(map[Value]int)}
+}
+
+func (*Map) Type() Type { return MapType }
+
+func (m *Map) String() string {
+	return m.inspect(make(map[Value]bool))
+}
+
+func (m *Map) inspect(seen map[Value]bool) string {
+	if seen[m] {
+		return "{...}"
+	}
+	seen[m] = true
+	defer delete(seen, m)
+
+	parts := make([]string, len(m.keys))
+	for n, key := range m.keys {
+		parts[n] = inspect(key, seen) + ": " + inspect(m.values[n], seen)
+	}
+
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func (m *Map) Len() int {
+	return len(m.keys)
+}
+
+// Keys returns the keys of the map in insertion order.
+func (m *Map) Keys() []Value {
+	return append([]Value(nil), m.keys...)
+}
+
+// Values returns the values of the map in the insertion order of their keys.
+func (m *Map) Values() []Value {
+	return append([]Value(nil), m.values...)
+}
+
+func (m *Map) Get(key Value) (Value, bool, error) {
+	key, err := hashKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+
+	n, ok := m.index[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	return m.values[n], true, nil
+}
+
+// Set inserts or replaces the value of key. Replacing a value does not move
+// the key in the iteration order.
+func (m *Map) Set(key, value Value) error {
+	key, err := hashKey(key)
+	if err != nil {
+		return err
+	}
+
+	if n, ok := m.index[key]; ok {
+		m.values[n] = value
+		return nil
+	}
+
+	m.index[key] = len(m.keys)
+	m.keys = append(m.keys, key)
+	m.values = append(m.values, value)
+	return nil
+}
+
+// Delete removes key from the map, reporting whether it was present.
+func (m *Map) Delete(key Value) (bool, error) {
+	key, err := hashKey(key)
+	if err != nil {
+		return false, err
+	}
+
+	n, ok := m.index[key]
+	if !ok {
+		return false, nil
+	}
+
+	delete(m.index, key)
+	m.keys = append(m.keys[:n], m.keys[n+1:]...)
+	m.values = append(m.values[:n], m.values[n+1:]...)
+	for ; n < len(m.keys); n++ {
+		m.index[m.keys[n]] = n
+	}
+
+	return true, nil
+}
+
+// hashKey normalizes a map key so that keys which are Equal share an entry.
+func hashKey(key Value) (Value, error) {
+	switch k := key.(type) {
+	case Null, Bool, Integer, String:
+		return key, nil
+	case Number:
+		if math.IsNaN(float64(k)) {
+			return nil, errors.New("NaN cannot be used as a map key")
+		}
+		if k >= math.MinInt64 && k < math.MaxInt64 && Number(Integer(k)) == k {
+			return Integer(k), nil
+		}
+		return key, nil
+	}
+
+	msg := fmt.Sprintf("%s cannot be used as a map key", key.Type())
+	return nil, errors.New(msg)
+}
+
+// Function is implemented by user-defined functions. The interpreter closes
+// over an environment while the VM executes compiled code, so each engine
+// provides its own representation.
+type Function interface {
+	Value
+	NumParams() int
+}
+
+type BuiltinFn func(args []Value) (Value, error)
+
+type Builtin struct {
+	Name string
+	Fn   BuiltinFn
+}
+
+func (*Builtin) Type() Type       { return BuiltinType }
+func (b *Builtin) String() string { return fmt.Sprintf("<builtin %s>", b.Name) }
+
+// Struct is a struct declaration. Its instances have exactly the declared
+// fields.
+type Struct struct {
+	Name   string
+	Fields []string
+	index  map[string]int
+}
+
+func NewStruct(name string, fields []string) *Struct {
+	index := make(map[string]int, len(fields))
+	for n, field := range fields {
+		index[field] = n
+	}
+
+	return &Struct{Name: name, Fields: fields, index: index}
+}
+
+func (*Struct) Type() Type       { return StructType }
+func (s *Struct) String() string { return fmt.Sprintf("<struct %s>", s.Name) }
+
+// Instance is a value of a struct. Its type is the name of the struct.
+type Instance struct {
+	Struct *Struct
+	Values []Value
+}
+
+// NewInstance returns an instance of s with every field set to null.
+func NewInstance(s *Struct) *Instance {
+	values := make([]Value, len(s.Fields))
+	for n := range values {
+		values[n] = Null{}
+	}
+
+	return &Instance{Struct: s, Values: values}
+}
+
+func (i *Instance) Type() Type { return Type(i.Struct.Name) }
+
+func (i *Instance) String() string {
+	return i.inspect(make(map[Value]bool))
+}
+
+func (i *Instance) inspect(seen map[Value]bool) string {
+	if seen[i] {
+		return i.Struct.Name + " {...}"
+	}
+	seen[i] = true
+	defer delete(seen, i)
+
+	var sb strings.Builder
+	sb.WriteString(i.Struct.Name + " {")
+
+	for n, field := range i.Struct.Fields {
+		if n > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, " %s: %s", field, inspect(i.Values[n], seen))
+	}
+
+	sb.WriteString(" }")
+	return sb.String()
+}
+
+func (i *Instance) Get(field string) (Value, error) {
+	n, ok := i.Struct.index[field]
+	if !ok {
+		return nil, i.fieldError(field)
+	}
+
+	return i.Values[n], nil
+}
+
+func (i *Instance) Set(field string, value Value) error {
+	n, ok := i.Struct.index[field]
+	if !ok {
+		return i.fieldError(field)
+	}
+
+	i.Values[n] = value
+	return nil
+}
+
+func (i *Instance) fieldError(field string) error {
+	msg := fmt.Sprintf("struct %s has no field '%s'", i.Struct.Name, field)
+	return errors.New(msg)
+}
+
+// Module is the namespace of an imported module. Its members are the globals
+// it declares, read from the globals of the engine that ran it so that later
+// assignments to them are seen.
+type Module struct {
+	Name    string
+	Globals map[string]Value
+}
+
+func (*Module) Type() Type       { return ModuleType }
+func (m *Module) String() string { return fmt.Sprintf("<module %s>", m.Name) }
+
+func (m *Module) Get(name string) (Value, error) {
+	// Builtins are available in every module so are not members of one
+	value, ok := m.Globals[name]
+	if _, builtin := value.(*Builtin); !ok || builtin {
+		msg := fmt.Sprintf("module %s has no member '%s'", m.Name, name)
+		return nil, errors.New(msg)
+	}
+
+	return value, nil
+}
+
+// Importer returns the module imported by an import statement with the given
+// path, initializing it the first time it is imported.
+type Importer func(path string) (*Module, error)
+
+// Inspect renders a value as it appears nested inside another value, quoting
+// strings so that "1" and 1 can be told apart.
+func Inspect(value Value) string {
+	return inspect(value, make(map[Value]bool))
+}
+
+// inspect renders a value nested in the containers in seen, which are being
+// printed. A container nested in itself is rendered as [...], {...} or
+// Name {...} the second time, as printing it again would never end.
+func inspect(value Value, seen map[Value]bool) string {
+	switch value := value.(type) {
+	case String:
+		return strconv.Quote(string(value))
+	case *Array:
+		return value.inspect(seen)
+	case *Map:
+		return value.inspect(seen)
+	case *Instance:
+		return value.inspect(seen)
+	}
+
+	return value.String()
+}
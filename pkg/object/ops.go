@@ -0,0 +1,361 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"blorbo/pkg/token"
+)
+
+// Truthy reports whether a value counts as true in a condition. Only null and
+// false are falsy; every other value, including 0 and "", is truthy.
+func Truthy(value Value) bool {
+	switch value := value.(type) {
+	case Null:
+		return false
+	case Bool:
+		return bool(value)
+	default:
+		return true
+	}
+}
+
+// Equal reports whether two values are equal. Integers and numbers compare
+// by numeric value, functions and builtins by identity, and values of any
+// other differing types are never equal.
+func Equal(left, right Value) bool {
+	switch l := left.(type) {
+	case Integer:
+		if r, ok := right.(Number); ok {
+			return Number(l) == r
+		}
+	case Number:
+		if r, ok := right.(Integer); ok {
+			return l == Number(r)
+		}
+	}
+
+	return left == right
+}
+
+// Binary applies a binary operator to two values:
+//
+//   - + - * on two integers yields an integer, wrapping on overflow; if
+//     either operand is a number both are converted to numbers.
+//   - / and % on two integers truncate towards zero and fail on a zero
+//     divisor; on numbers they follow IEEE 754 and math.Mod.
+//   - + with a string operand concatenates the string forms of both.
+//   - < <= > >= compare numbers numerically and strings lexically.
+//   - & | ^ << >> are only defined on integers. Shifting by a negative
+//     count fails, shifting by 64 or more yields 0 (or -1 for >> on a
+//     negative value).
+//   - == and != are defined on all values, see Equal.
+//
+// The logical operators "and" and "or" short-circuit, so engines evaluate
+// them directly rather than through Binary.
+func Binary(op token.TokenType, left, right Value) (Value, error) {
+	switch op {
+	case token.Equal:
+		return Bool(Equal(left, right)), nil
+	case token.NotEqual:
+		return Bool(!Equal(left, right)), nil
+	}
+
+	if op == token.Add {
+		_, lok := left.(String)
+		_, rok := right.(String)
+		if lok || rok {
+			return String(left.String() + right.String()), nil
+		}
+	}
+
+	switch l := left.(type) {
+	case Integer:
+		switch r := right.(type) {
+		case Integer:
+			return integerOp(op, l, r)
+		case Number:
+			return numberOp(op, Number(l), r)
+		}
+	case Number:
+		switch r := right.(type) {
+		case Integer:
+			return numberOp(op, l, Number(r))
+		case Number:
+			return numberOp(op, l, r)
+		}
+	case String:
+		if r, ok := right.(String); ok {
+			return stringOp(op, l, r)
+		}
+	}
+
+	return nil, operandError(op, left, right)
+}
+
+func integerOp(op token.TokenType, l, r Integer) (Value, error) {
+	switch op {
+	case token.Add:
+		return l + r, nil
+	case token.Sub:
+		return l - r, nil
+	case token.Mul:
+		return l * r, nil
+	case token.Div:
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		if r == -1 {
+			// Avoid the runtime panic on math.MinInt64 / -1
+			return -l, nil
+		}
+		return l / r, nil
+	case token.Mod:
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		if r == -1 {
+			return Integer(0), nil
+		}
+		return l % r, nil
+	case token.Greater:
+		return Bool(l > r), nil
+	case token.GreaterEqual:
+		return Bool(l >= r), nil
+	case token.Less:
+		return Bool(l < r), nil
+	case token.LessEqual:
+		return Bool(l <= r), nil
+	case token.BitAnd:
+		return l & r, nil
+	case token.BitOr:
+		return l | r, nil
+	case token.BitXor:
+		return l ^ r, nil
+	case token.LeftShift:
+		if r < 0 {
+			return nil, errors.New("negative shift count")
+		}
+		return l << uint64(r), nil
+	case token.RightShift:
+		if r < 0 {
+			return nil, errors.New("negative shift count")
+		}
+		return l >> uint64(r), nil
+	}
+
+	return nil, operandError(op, l, r)
+}
+
+func numberOp(op token.TokenType, l, r Number) (Value, error) {
+	switch op {
+	case token.Add:
+		return l + r, nil
+	case token.Sub:
+		return l - r, nil
+	case token.Mul:
+		return l * r, nil
+	case token.Div:
+		return l / r, nil
+	case token.Mod:
+		return Number(math.Mod(float64(l), float64(r))), nil
+	case token.Greater:
+		return Bool(l > r), nil
+	case token.GreaterEqual:
+		return Bool(l >= r), nil
+	case token.Less:
+		return Bool(l < r), nil
+	case token.LessEqual:
+		return Bool(l <= r), nil
+	}
+
+	return nil, operandError(op, l, r)
+}
+
+func stringOp(op token.TokenType, l, r String) (Value, error) {
+	switch op {
+	case token.Greater:
+		return Bool(l > r), nil
+	case token.GreaterEqual:
+		return Bool(l >= r), nil
+	case token.Less:
+		return Bool(l < r), nil
+	case token.LessEqual:
+		return Bool(l <= r), nil
+	}
+
+	return nil, operandError(op, l, r)
+}
+
+// Unary applies a unary operator to a value. + and - are defined on integers
+// and numbers, ~ only on integers, and ! on every value, see Truthy.
+func Unary(op token.TokenType, right Value) (Value, error) {
+	if op == token.Not {
+		return Bool(!Truthy(right)), nil
+	}
+
+	switch r := right.(type) {
+	case Integer:
+		switch op {
+		case token.Add:
+			return r, nil
+		case token.Sub:
+			return -r, nil
+		case token.BitNot:
+			return ^r, nil
+		}
+	case Number:
+		switch op {
+		case token.Add:
+			return r, nil
+		case token.Sub:
+			return -r, nil
+		}
+	}
+
+	msg := fmt.Sprintf("invalid operand for '%s': %s", symbols[op], right.Type())
+	return nil, errors.New(msg)
+}
+
+var symbols = map[token.TokenType]string{
+	token.Mul:          "*",
+	token.Div:          "/",
+	token.Mod:          "%",
+	token.Add:          "+",
+	token.Sub:          "-",
+	token.Equal:        "==",
+	token.Not:          "!",
+	token.NotEqual:     "!=",
+	token.Greater:      ">",
+	token.GreaterEqual: ">=",
+	token.Less:         "<",
+	token.LessEqual:    "<=",
+	token.BitAnd:       "&",
+	token.BitOr:        "|",
+	token.BitXor:       "^",
+	token.BitNot:       "~",
+	token.RightShift:   ">>",
+	token.LeftShift:    "<<",
+}
+
+func operandError(op token.TokenType, left, right Value) error {
+	msg := fmt.Sprintf(
+		"invalid operands for '%s': %s and %s",
+		symbols[op], left.Type(), right.Type(),
+	)
+	return errors.New(msg)
+}
+
+// GetField reads a field of an instance or a member of a module.
+func GetField(obj Value, name string) (Value, error) {
+	if m, ok := obj.(*Module); ok {
+		return m.Get(name)
+	}
+
+	instance, ok := obj.(*Instance)
+	if !ok {
+		msg := fmt.Sprintf("cannot read field '%s' of %s", name, obj.Type())
+		return nil, errors.New(msg)
+	}
+
+	return instance.Get(name)
+}
+
+// SetField writes a field of an instance.
+func SetField(obj Value, name string, value Value) error {
+	instance, ok := obj.(*Instance)
+	if !ok {
+		msg := fmt.Sprintf("cannot set field '%s' of %s", name, obj.Type())
+		return errors.New(msg)
+	}
+
+	return instance.Set(name, value)
+}
+
+// Instantiate creates an instance of a struct from a struct literal. Fields
+// not given a value are null.
+func Instantiate(callee Value, fields []string, values []Value) (Value, error) {
+	s, ok := callee.(*Struct)
+	if !ok {
+		msg := fmt.Sprintf("cannot instantiate %s", callee.Type())
+		return nil, errors.New(msg)
+	}
+
+	instance := NewInstance(s)
+	for n, field := range fields {
+		if err := instance.Set(field, values[n]); err != nil {
+			return nil, err
+		}
+	}
+
+	return instance, nil
+}
+
+// Index reads an element of an array, the value of a key in a map, or a
+// single byte of a string.
+func Index(obj, index Value) (Value, error) {
+	switch obj := obj.(type) {
+	case *Map:
+		value, ok, err := obj.Get(index)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			msg := fmt.Sprintf("key %s not found in map", Inspect(index))
+			return nil, errors.New(msg)
+		}
+		return value, nil
+	case *Array:
+		n, err := checkIndex(index, len(obj.Elements))
+		if err != nil {
+			return nil, err
+		}
+		return obj.Elements[n], nil
+	case String:
+		n, err := checkIndex(index, len(obj))
+		if err != nil {
+			return nil, err
+		}
+		return obj[n : n+1], nil
+	}
+
+	msg := fmt.Sprintf("cannot index %s", obj.Type())
+	return nil, errors.New(msg)
+}
+
+// SetIndex writes an element of an array, or the value of a key in a map.
+func SetIndex(obj, index, value Value) error {
+	if m, ok := obj.(*Map); ok {
+		return m.Set(index, value)
+	}
+
+	array, ok := obj.(*Array)
+	if !ok {
+		msg := fmt.Sprintf("cannot assign to index of %s", obj.Type())
+		return errors.New(msg)
+	}
+
+	n, err := checkIndex(index, len(array.Elements))
+	if err != nil {
+		return err
+	}
+
+	array.Elements[n] = value
+	return nil
+}
+
+func checkIndex(index Value, length int) (int, error) {
+	n, ok := index.(Integer)
+	if !ok {
+		msg := fmt.Sprintf("index must be an int, not %s", index.Type())
+		return 0, errors.New(msg)
+	}
+
+	if n < 0 || n >= Integer(length) {
+		msg := fmt.Sprintf("index %d out of range for length %d", n, length)
+		return 0, errors.New(msg)
+	}
+
+	return int(n), nil
+}
@@ -1,45 +1,142 @@
 package parser
 
 import (
-	"errors"
-	"fmt"
+	"path"
+	"strings"
 
 	"blorbo/pkg/ast"
+	"blorbo/pkg/diag"
 	"blorbo/pkg/token"
 )
 
 type Parser struct {
 	tokens []token.Token
 	pos    int
+	diags  diag.List
+
+	// last is the position of the last error recorded
+	last token.Pos
 }
 
 func New(tokens []token.Token) *Parser {
+	// The parser relies on the tokens ending with Eof, which is never
+	// consumed, to stay in bounds
+	if len(tokens) == 0 || tokens[len(tokens)-1].Type != token.Eof {
+		var end token.Pos
+		if len(tokens) > 0 {
+			end = tokens[len(tokens)-1].End
+		}
+
+		eof := token.Token{Type: token.Eof, Pos: end, End: end}
+		tokens = append(tokens[:len(tokens):len(tokens)], eof)
+	}
+
 	return &Parser{tokens: tokens}
 }
 
+// Parse parses a program, reporting every syntax error found as a
+// diag.List. The program is returned even if there are errors, with BadStmt
+// and BadExpr nodes in place of the code that could not be parsed.
+//
 // Program -> Stmt* Eof
 func (p *Parser) Parse() (*ast.Program, error) {
 	program := ast.Program{}
 
 	for !p.checkToken(token.Eof) {
-		stmt, err := p.parseStmt()
-		if err != nil {
-			return nil, err
+		program.Stmts = append(program.Stmts, p.recoverStmt())
+	}
+
+	return &program, p.diags.Err()
+}
+
+// recoverStmt parses a statement. On a syntax error it records the error and
+// skips to the start of the next statement, returning a BadStmt in place of
+// the tokens skipped.
+func (p *Parser) recoverStmt() ast.Stmt {
+	pos := p.pos
+	start := p.here()
+
+	stmt, err := p.parseStmt()
+	if err == nil {
+		return stmt
+	}
+
+	p.report(err)
+	p.synchronize(pos)
+	return &ast.BadStmt{Span: p.span(start)}
+}
+
+// synchronize skips tokens up to the end of the statement that failed to
+// parse, stopping after a ";" or before a "}" or a keyword that begins a
+// statement. At least one token is skipped if the statement began at pos and
+// no tokens were consumed, so that parsing always makes progress.
+func (p *Parser) synchronize(pos int) {
+	if p.pos == pos {
+		p.advance()
+	}
+
+	for !p.checkToken(token.Eof) {
+		if p.prevToken().Type == token.Semicolon {
+			return
 		}
 
-		program.Stmts = append(program.Stmts, stmt)
+		switch p.peek().Type {
+		case token.RightBrace,
+			token.If,
+			token.While,
+			token.For,
+			token.Struct,
+			token.Fn,
+			token.Var,
+			token.Return,
+			token.Import,
+			token.Break,
+			token.Continue:
+			return
+		}
+
+		p.advance()
 	}
+}
 
-	return &program, nil
+func (p *Parser) report(err error) {
+	// The lexer has already reported the error for an Illegal token, so the
+	// syntax error it causes is not reported again
+	if p.checkToken(token.Illegal) || p.prevToken().Type == token.Illegal {
+		return
+	}
+
+	if d, ok := err.(*diag.Diagnostic); ok {
+		p.record(d)
+	} else {
+		p.record(diag.New(diag.Syntax, p.peek().Span(), "%s", err))
+	}
+}
+
+// record records a syntax error. An error at the same position as the last
+// one is left out, as it follows from that error rather than the source, like
+// the missing ')' in "(;" after its missing expression.
+func (p *Parser) record(d *diag.Diagnostic) {
+	if d.Span.Start == p.last {
+		return
+	}
+
+	p.last = d.Span.Start
+	p.diags = append(p.diags, d)
 }
 
 // Stmt -> BlockStmt
 // | IfStmt
 // | WhileStmt
 // | ForStmt
+// | StructStmt
 // | FnStmt
 // | VarStmt
 // | ReturnStmt
+// | ImportStmt
+// | BreakStmt
+// | ContinueStmt
+// | LabeledStmt
 // | ExprStmt
 func (p *Parser) parseStmt() (ast.Stmt, error) {
 	// BlockStmt
@@ -63,10 +160,14 @@ func (p *Parser) parseStmt() (ast.Stmt, error) {
 	}
 
 	// StructStmt
-	// TODO StructStmt
+	if p.matchToken(token.Struct) {
+		return p.parseStructStmt()
+	}
 
 	// FnStmt
-	if p.matchToken(token.Fn) {
+	// An "fn" not followed by a name begins a FnExpr in an ExprStmt
+	if p.checkToken(token.Fn) && p.checkNext(token.Ident) {
+		p.matchToken(token.Fn)
 		return p.parseFnStmt()
 	}
 
@@ -80,21 +181,38 @@ func (p *Parser) parseStmt() (ast.Stmt, error) {
 		return p.parseReturnStmt()
 	}
 
+	// ImportStmt
+	if p.matchToken(token.Import) {
+		return p.parseImportStmt()
+	}
+
+	// BreakStmt
+	if p.matchToken(token.Break) {
+		return p.parseBreakStmt()
+	}
+
+	// ContinueStmt
+	if p.matchToken(token.Continue) {
+		return p.parseContinueStmt()
+	}
+
+	// LabeledStmt
+	if p.checkToken(token.Ident) && p.checkNext(token.Colon) {
+		return p.parseLabeledStmt()
+	}
+
 	// ExprStmt
 	return p.parseExprStmt()
 }
 
 // BlockStmt -> "{" Stmt* "}"
 func (p *Parser) parseBlockStmt() (ast.Stmt, error) {
+	start := p.prevToken().Pos
+
 	// Stmt*
 	var stmts []ast.Stmt
 	for !p.checkToken(token.RightBrace) && !p.checkToken(token.Eof) {
-		stmt, err := p.parseStmt()
-		if err != nil {
-			return nil, err
-		}
-
-		stmts = append(stmts, stmt)
+		stmts = append(stmts, p.recoverStmt())
 	}
 
 	msg := "expected '}' after statements"
@@ -102,17 +220,19 @@ func (p *Parser) parseBlockStmt() (ast.Stmt, error) {
 		return nil, err
 	}
 
-	return ast.BlockStmt{Body: stmts}, nil
+	return &ast.BlockStmt{Body: stmts, Span: p.span(start)}, nil
 }
 
 // IfStmt -> "if" "(" Expr ")" Stmt ( "else" Stmt )?
 func (p *Parser) parseIfStmt() (ast.Stmt, error) {
+	start := p.prevToken().Pos
+
 	msg := "expected '(' after if statement"
 	if _, err := p.expectToken(token.LeftParen, msg); err != nil {
 		return nil, err
 	}
 
-	cond, err := p.parseExpr()
+	cond, err := p.required(p.parseExpr())
 	if err != nil {
 		return nil, err
 	}
@@ -136,17 +256,19 @@ func (p *Parser) parseIfStmt() (ast.Stmt, error) {
 		}
 	}
 
-	return ast.IfStmt{Cond: cond, If: ifStmt, Else: elseStmt}, nil
+	return &ast.IfStmt{Cond: cond, If: ifStmt, Else: elseStmt, Span: p.span(start)}, nil
 }
 
 // WhileStmt -> "while" "(" Expr ")" Stmt
 func (p *Parser) parseWhileStmt() (ast.Stmt, error) {
+	start := p.prevToken().Pos
+
 	msg := "expected '(' after while statement"
 	if _, err := p.expectToken(token.LeftParen, msg); err != nil {
 		return nil, err
 	}
 
-	cond, err := p.parseExpr()
+	cond, err := p.required(p.parseExpr())
 	if err != nil {
 		return nil, err
 	}
@@ -161,11 +283,13 @@ func (p *Parser) parseWhileStmt() (ast.Stmt, error) {
 		return nil, err
 	}
 
-	return ast.WhileStmt{Cond: cond, Body: stmt}, nil
+	return &ast.WhileStmt{Cond: cond, Body: stmt, Span: p.span(start)}, nil
 }
 
-// ForStmt -> "for" "(" ( ";" | VarStmt | ExprStmt ) Expr? ";" Expr? ")" Stmt
+// ForStmt -> "for" "(" ( ";" | VarStmt | ExprStmt ) Expr? ";" Update? ")" Stmt
 func (p *Parser) parseForStmt() (ast.Stmt, error) {
+	start := p.prevToken().Pos
+
 	msg := "expected '(' after for statement"
 	if _, err := p.expectToken(token.LeftParen, msg); err != nil {
 		return nil, err
@@ -203,10 +327,10 @@ func (p *Parser) parseForStmt() (ast.Stmt, error) {
 		return nil, err
 	}
 
-	// Expr? ")"
+	// Update? ")"
 	var inc ast.Expr = nil
 	if !p.checkToken(token.RightParen) {
-		expr, err := p.parseExpr()
+		expr, err := p.parseUpdate()
 		if err != nil {
 			return nil, err
 		}
@@ -222,12 +346,60 @@ func (p *Parser) parseForStmt() (ast.Stmt, error) {
 		return nil, err
 	}
 
-	return ast.ForStmt{Init: init, Cond: cond, Inc: inc, Body: stmt}, nil
+	return &ast.ForStmt{Init: init, Cond: cond, Inc: inc, Body: stmt, Span: p.span(start)}, nil
+}
+
+// StructStmt -> "struct" Ident "{" Fields? "}"
+// Fields -> Ident ( "," Ident )*
+func (p *Parser) parseStructStmt() (ast.Stmt, error) {
+	start := p.prevToken().Pos
+
+	msg := "expected struct name"
+	ident, err := p.expectToken(token.Ident, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	msg = "expected '{' after struct name"
+	if _, err := p.expectToken(token.LeftBrace, msg); err != nil {
+		return nil, err
+	}
+
+	// Fields?
+	var fields []token.Token
+	if !p.checkToken(token.RightBrace) {
+		for ok := true; ok; ok = p.matchToken(token.Comma) {
+			msg := "expected field name"
+			field, err := p.expectToken(token.Ident, msg)
+			if err != nil {
+				return nil, err
+			}
+
+			// As in a struct literal, the rest of the declaration still
+			// parses after a duplicate
+			for _, prev := range fields {
+				if prev.Literal == field.Literal {
+					p.record(diag.New(diag.Syntax, field.Span(), "duplicate field '%s'", field.Literal))
+					break
+				}
+			}
+
+			fields = append(fields, field)
+		}
+	}
+
+	msg = "expected '}' after struct fields"
+	if _, err := p.expectToken(token.RightBrace, msg); err != nil {
+		return nil, err
+	}
+
+	return &ast.StructStmt{Name: ident, Fields: fields, Span: p.span(start)}, nil
 }
 
-// FnStmt -> "fn" Ident "(" Params? ")"
-// Params -> Ident ( "," Ident )*
+// FnStmt -> "fn" Ident "(" Params? ")" Result Stmt
 func (p *Parser) parseFnStmt() (ast.Stmt, error) {
+	start := p.prevToken().Pos
+
 	msg := "expected function name"
 	ident, err := p.expectToken(token.Ident, msg)
 	if err != nil {
@@ -239,50 +411,119 @@ func (p *Parser) parseFnStmt() (ast.Stmt, error) {
 		return nil, err
 	}
 
+	params, types, err := p.parseParams()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := p.parseResult()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := p.parseStmt()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.FnStmt{
+		Name:       ident,
+		Params:     params,
+		ParamTypes: types,
+		Result:     result,
+		Body:       stmt,
+		Span:       p.span(start),
+	}, nil
+}
+
+// Params -> Param ( "," Param )*
+// Param -> Ident ( ":" Type )?
+func (p *Parser) parseParams() ([]token.Token, []*ast.TypeExpr, error) {
 	var params []token.Token
+	var types []*ast.TypeExpr
 	for ok := true; ok; ok = p.matchToken(token.Comma) {
+		start := p.here()
 		expr, err := p.parseExpr()
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		if expr != nil {
-			ident, ok := expr.(ast.IdentExpr)
+			ident, ok := expr.(*ast.IdentExpr)
 			if !ok {
-				line := p.tokens[p.pos].Line
-				msg := fmt.Sprintf("invalid parameter name on line %d", line)
-				return nil, errors.New(msg)
+				return nil, nil, diag.New(diag.Syntax, p.span(start), "invalid parameter name")
+			}
+
+			// ( ":" Type )?
+			var ty *ast.TypeExpr
+			if p.matchToken(token.Colon) {
+				ty, err = p.parseType()
+				if err != nil {
+					return nil, nil, err
+				}
 			}
 
 			params = append(params, ident.Name)
+			types = append(types, ty)
 		}
 	}
 
-	msg = "expected ')' after parameters"
+	msg := "expected ')' after parameters"
 	if _, err := p.expectToken(token.RightParen, msg); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	stmt, err := p.parseStmt()
+	return params, types, nil
+}
+
+// Result -> ( ":" Type )?
+func (p *Parser) parseResult() (*ast.TypeExpr, error) {
+	if !p.matchToken(token.Colon) {
+		return nil, nil
+	}
+
+	return p.parseType()
+}
+
+// Type -> Ident | "fn" | "null"
+func (p *Parser) parseType() (*ast.TypeExpr, error) {
+	if p.matchToken(token.Fn) || p.matchToken(token.Null) {
+		name := p.prevToken()
+		return &ast.TypeExpr{Name: name, Span: name.Span()}, nil
+	}
+
+	msg := "expected type name"
+	ident, err := p.expectToken(token.Ident, msg)
 	if err != nil {
 		return nil, err
 	}
 
-	return ast.FnStmt{Name: ident, Params: params, Body: stmt}, nil
+	return &ast.TypeExpr{Name: ident, Span: ident.Span()}, nil
 }
 
-// VarStmt -> "var" Ident ( "=" Expr )? ";"
+// VarStmt -> "var" Ident ( ":" Type )? ( "=" Expr )? ";"
 func (p *Parser) parseVarStmt() (ast.Stmt, error) {
+	start := p.prevToken().Pos
+
 	msg := "expected variable name"
 	ident, err := p.expectToken(token.Ident, msg)
 	if err != nil {
 		return nil, err
 	}
 
+	// ( ":" Type )?
+	var ty *ast.TypeExpr
+	if p.matchToken(token.Colon) {
+		ty, err = p.parseType()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// ( "=" Expr)?
 	var expr ast.Expr
 	if p.matchToken(token.Assign) {
-		expr, err = p.parseExpr()
+		expr, err = p.required(p.parseExpr())
 		if err != nil {
 			return nil, err
 		}
@@ -293,11 +534,13 @@ func (p *Parser) parseVarStmt() (ast.Stmt, error) {
 		return nil, err
 	}
 
-	return ast.VarStmt{Name: ident, Value: expr}, nil
+	return &ast.VarStmt{Name: ident, Type: ty, Value: expr, Span: p.span(start)}, nil
 }
 
 // ReturnStmt -> "return" Expr ";"
 func (p *Parser) parseReturnStmt() (ast.Stmt, error) {
+	keyword := p.prevToken()
+
 	expr, err := p.parseExpr()
 	if err != nil {
 		return nil, err
@@ -308,12 +551,126 @@ func (p *Parser) parseReturnStmt() (ast.Stmt, error) {
 		return nil, err
 	}
 
-	return ast.ReturnStmt{Value: expr}, nil
+	return &ast.ReturnStmt{Keyword: keyword, Value: expr, Span: p.span(keyword.Pos)}, nil
+}
+
+// BreakStmt -> "break" Ident? ";"
+func (p *Parser) parseBreakStmt() (ast.Stmt, error) {
+	keyword := p.prevToken()
+
+	label, err := p.parseBranchLabel("break")
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.BreakStmt{Keyword: keyword, Label: label, Span: p.span(keyword.Pos)}, nil
+}
+
+// ContinueStmt -> "continue" Ident? ";"
+func (p *Parser) parseContinueStmt() (ast.Stmt, error) {
+	keyword := p.prevToken()
+
+	label, err := p.parseBranchLabel("continue")
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.ContinueStmt{Keyword: keyword, Label: label, Span: p.span(keyword.Pos)}, nil
+}
+
+// parseBranchLabel parses the optional label and the ";" ending a break or
+// continue statement.
+func (p *Parser) parseBranchLabel(keyword string) (token.Token, error) {
+	var label token.Token
+	if p.matchToken(token.Ident) {
+		label = p.prevToken()
+	}
+
+	msg := "expected ';' after " + keyword
+	if _, err := p.expectToken(token.Semicolon, msg); err != nil {
+		return token.Token{}, err
+	}
+
+	return label, nil
 }
 
-// ExprStmt -> Expr ";"
+// LabeledStmt -> Ident ":" ( WhileStmt | ForStmt )
+func (p *Parser) parseLabeledStmt() (ast.Stmt, error) {
+	label := p.peek()
+	p.advance()
+	p.advance()
+
+	switch {
+	case p.matchToken(token.While):
+		stmt, err := p.parseWhileStmt()
+		if err != nil {
+			return nil, err
+		}
+
+		loop := stmt.(*ast.WhileStmt)
+		loop.Label = label
+		loop.Span.Start = label.Pos
+		return loop, nil
+	case p.matchToken(token.For):
+		stmt, err := p.parseForStmt()
+		if err != nil {
+			return nil, err
+		}
+
+		loop := stmt.(*ast.ForStmt)
+		loop.Label = label
+		loop.Span.Start = label.Pos
+		return loop, nil
+	}
+
+	return nil, diag.New(diag.Syntax, p.peek().Span(), "expected loop after label '%s'", label.Literal)
+}
+
+// ImportStmt -> "import" String ";"
+func (p *Parser) parseImportStmt() (ast.Stmt, error) {
+	keyword := p.prevToken()
+
+	msg := "expected module path after import"
+	file, err := p.expectToken(token.String, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	// The module is bound to the base name of its path, which must be a
+	// valid identifier
+	base := path.Base(file.Literal)
+	base = strings.TrimSuffix(base, path.Ext(base))
+	if !isIdent(base) {
+		return nil, diag.New(diag.Syntax, file.Span(), "invalid module name '%s'", base)
+	}
+
+	msg = "expected ';' after import"
+	if _, err := p.expectToken(token.Semicolon, msg); err != nil {
+		return nil, err
+	}
+
+	name := file
+	name.Type = token.Ident
+	name.Literal = base
+
+	return &ast.ImportStmt{Keyword: keyword, Path: file, Name: name, Span: p.span(keyword.Pos)}, nil
+}
+
+func isIdent(name string) bool {
+	for n, c := range name {
+		alpha := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_'
+		if !alpha && (n == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+
+	return name != ""
+}
+
+// ExprStmt -> Update ";"
 func (p *Parser) parseExprStmt() (ast.Stmt, error) {
-	expr, err := p.parseExpr()
+	start := p.here()
+	expr, err := p.parseUpdate()
 	if err != nil {
 		return nil, err
 	}
@@ -323,6 +680,30 @@ func (p *Parser) parseExprStmt() (ast.Stmt, error) {
 		return nil, err
 	}
 
+	return &ast.ExprStmt{
+		Value: expr,
+		Span:  p.span(start),
+	}, nil
+}
+
+// Update -> Call ( "++" | "--" )
+// | Expr
+func (p *Parser) parseUpdate() (ast.Expr, error) {
+	start := p.here()
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	// x++ is x += 1, with the "++" standing for the 1. It is only a
+	// statement, so that it has no value to be confused about.
+	if p.matchToken(token.Increment) || p.matchToken(token.Decrement) {
+		op := p.prevToken()
+		one := token.Token{Type: token.Int, Literal: "1", Pos: op.Pos, End: op.End}
+		return p.assignment(expr, op, &ast.LiteralExpr{Value: one, Span: op.Span()}, start)
+	}
+
 	return expr, nil
 }
 
@@ -331,35 +712,75 @@ func (p *Parser) parseExpr() (ast.Expr, error) {
 	return p.parseAssign()
 }
 
-// Assign -> Ident "=" Assign
+// Assign -> ( Call "." )? Ident AssignOp Assign
+// | Call "[" Expr "]" AssignOp Assign
 // | LogicalOr
+// AssignOp -> "=" | "+=" | "-=" | "*=" | "/=" | "%=" | "&=" | "|=" | "^="
+// | "<<=" | ">>="
 func (p *Parser) parseAssign() (ast.Expr, error) {
+	start := p.here()
+
 	expr, err := p.parseLogicalOr()
 	if err != nil {
 		return nil, err
 	}
 
-	if p.matchToken(token.Assign) {
-		value, err := p.parseAssign()
+	if p.matchToken(token.Assign) || p.matchCompound() {
+		op := p.prevToken()
+		value, err := p.required(p.parseAssign())
 		if err != nil {
 			return nil, err
 		}
 
-		expr, ok := expr.(ast.IdentExpr)
-		if !ok {
-			line := p.tokens[p.pos].Line
-			msg := fmt.Sprintf("invalid assignment target on line %d", line)
-			return nil, errors.New(msg)
-		}
-
-		return ast.AssignExpr{Name: expr.Name, Value: value}, nil
+		return p.assignment(expr, op, value, start)
 	}
 
 	return expr, nil
 }
 
+// matchCompound consumes the next token if it is a compound assignment
+// operator other than "++" and "--".
+func (p *Parser) matchCompound() bool {
+	ty := p.peek().Type
+	if _, ok := token.Compound(ty); !ok || ty == token.Increment || ty == token.Decrement {
+		return false
+	}
+
+	p.advance()
+	return true
+}
+
+// assignment returns the assignment of value to target with the operator op.
+func (p *Parser) assignment(target ast.Expr, op token.Token, value ast.Expr, start token.Pos) (ast.Expr, error) {
+	switch target := target.(type) {
+	case *ast.IdentExpr:
+		return &ast.AssignExpr{Name: target.Name, Op: op, Value: value, Span: p.span(start)}, nil
+	case *ast.GetExpr:
+		return &ast.SetExpr{
+			Object: target.Object,
+			Name:   target.Name,
+			Op:     op,
+			Value:  value,
+			Span:   p.span(start),
+		}, nil
+	case *ast.IndexExpr:
+		return &ast.SetIndexExpr{
+			Object:  target.Object,
+			Bracket: target.Bracket,
+			Index:   target.Index,
+			Op:      op,
+			Value:   value,
+			Span:    p.span(start),
+		}, nil
+	default:
+		return nil, diag.New(diag.Syntax, p.span(start), "invalid assignment target")
+	}
+}
+
 // LogicalOr -> LogicalAnd ( "or" LogicalAnd )*
 func (p *Parser) parseLogicalOr() (ast.Expr, error) {
+	start := p.here()
+
 	expr, err := p.parseLogicalAnd()
 	if err != nil {
 		return nil, err
@@ -368,12 +789,13 @@ func (p *Parser) parseLogicalOr() (ast.Expr, error) {
 	// ( "or" LogicalAnd )*
 	for p.matchToken(token.Or) {
 		op := p.prevToken()
-		right, err := p.parseLogicalAnd()
+		left := p.operand(expr, op)
+		right, err := p.required(p.parseLogicalAnd())
 		if err != nil {
 			return nil, err
 		}
 
-		expr = ast.BinaryExpr{Left: expr, Op: op, Right: right}
+		expr = &ast.BinaryExpr{Left: left, Op: op, Right: right, Span: p.span(start)}
 	}
 
 	return expr, nil
@@ -381,6 +803,8 @@ func (p *Parser) parseLogicalOr() (ast.Expr, error) {
 
 // LogicalAnd -> BitwiseOr ( "and" BitwiseOr )*
 func (p *Parser) parseLogicalAnd() (ast.Expr, error) {
+	start := p.here()
+
 	expr, err := p.parseBitwiseOr()
 	if err != nil {
 		return nil, err
@@ -389,12 +813,13 @@ func (p *Parser) parseLogicalAnd() (ast.Expr, error) {
 	// ( "and" BitwiseOr )*
 	for p.matchToken(token.And) {
 		op := p.prevToken()
-		right, err := p.parseBitwiseOr()
+		left := p.operand(expr, op)
+		right, err := p.required(p.parseBitwiseOr())
 		if err != nil {
 			return nil, err
 		}
 
-		expr = ast.BinaryExpr{Left: expr, Op: op, Right: right}
+		expr = &ast.BinaryExpr{Left: left, Op: op, Right: right, Span: p.span(start)}
 	}
 
 	return expr, nil
@@ -402,6 +827,8 @@ func (p *Parser) parseLogicalAnd() (ast.Expr, error) {
 
 // BitwiseOr -> BitwiseXor ( "|" BitwiseXor )*
 func (p *Parser) parseBitwiseOr() (ast.Expr, error) {
+	start := p.here()
+
 	expr, err := p.parseBitwiseXor()
 	if err != nil {
 		return nil, err
@@ -410,12 +837,13 @@ func (p *Parser) parseBitwiseOr() (ast.Expr, error) {
 	// ( "|" BitwiseXor )*
 	for p.matchToken(token.BitOr) {
 		op := p.prevToken()
-		right, err := p.parseBitwiseXor()
+		left := p.operand(expr, op)
+		right, err := p.required(p.parseBitwiseXor())
 		if err != nil {
 			return nil, err
 		}
 
-		expr = ast.BinaryExpr{Left: expr, Op: op, Right: right}
+		expr = &ast.BinaryExpr{Left: left, Op: op, Right: right, Span: p.span(start)}
 	}
 
 	return expr, nil
@@ -423,6 +851,8 @@ func (p *Parser) parseBitwiseOr() (ast.Expr, error) {
 
 // BitwiseXor -> BitwiseAnd ( "^" BitwiseAnd )*
 func (p *Parser) parseBitwiseXor() (ast.Expr, error) {
+	start := p.here()
+
 	expr, err := p.parseBitwiseAnd()
 	if err != nil {
 		return nil, err
@@ -431,12 +861,13 @@ func (p *Parser) parseBitwiseXor() (ast.Expr, error) {
 	// ( "^" BitwiseAnd )*
 	for p.matchToken(token.BitXor) {
 		op := p.prevToken()
-		right, err := p.parseBitwiseAnd()
+		left := p.operand(expr, op)
+		right, err := p.required(p.parseBitwiseAnd())
 		if err != nil {
 			return nil, err
 		}
 
-		expr = ast.BinaryExpr{Left: expr, Op: op, Right: right}
+		expr = &ast.BinaryExpr{Left: left, Op: op, Right: right, Span: p.span(start)}
 	}
 
 	return expr, nil
@@ -444,6 +875,8 @@ func (p *Parser) parseBitwiseXor() (ast.Expr, error) {
 
 // BitwiseAnd -> Equality ( "&" Equality )*
 func (p *Parser) parseBitwiseAnd() (ast.Expr, error) {
+	start := p.here()
+
 	expr, err := p.parseEquality()
 	if err != nil {
 		return nil, err
@@ -452,12 +885,13 @@ func (p *Parser) parseBitwiseAnd() (ast.Expr, error) {
 	// ( "&" Equality )*
 	for p.matchToken(token.BitAnd) {
 		op := p.prevToken()
-		right, err := p.parseEquality()
+		left := p.operand(expr, op)
+		right, err := p.required(p.parseEquality())
 		if err != nil {
 			return nil, err
 		}
 
-		expr = ast.BinaryExpr{Left: expr, Op: op, Right: right}
+		expr = &ast.BinaryExpr{Left: left, Op: op, Right: right, Span: p.span(start)}
 	}
 
 	return expr, nil
@@ -465,6 +899,8 @@ func (p *Parser) parseBitwiseAnd() (ast.Expr, error) {
 
 // Equality -> Comparison ( ( "==" | "!=" ) Comparison )*
 func (p *Parser) parseEquality() (ast.Expr, error) {
+	start := p.here()
+
 	expr, err := p.parseComparison()
 	if err != nil {
 		return nil, err
@@ -473,12 +909,13 @@ func (p *Parser) parseEquality() (ast.Expr, error) {
 	// ( ( "==" | "!=" ) Comparison )*
 	for p.matchToken(token.Equal) || p.matchToken(token.NotEqual) {
 		op := p.prevToken()
-		right, err := p.parseComparison()
+		left := p.operand(expr, op)
+		right, err := p.required(p.parseComparison())
 		if err != nil {
 			return nil, err
 		}
 
-		expr = ast.BinaryExpr{Left: expr, Op: op, Right: right}
+		expr = &ast.BinaryExpr{Left: left, Op: op, Right: right, Span: p.span(start)}
 	}
 
 	return expr, nil
@@ -486,6 +923,8 @@ func (p *Parser) parseEquality() (ast.Expr, error) {
 
 // Comparison -> BitShift ( ( ">" | ">=" | "<" | "<=" ) BitShift )*
 func (p *Parser) parseComparison() (ast.Expr, error) {
+	start := p.here()
+
 	expr, err := p.parseBitShift()
 	if err != nil {
 		return nil, err
@@ -498,12 +937,13 @@ func (p *Parser) parseComparison() (ast.Expr, error) {
 		p.matchToken(token.LessEqual) {
 
 		op := p.prevToken()
-		right, err := p.parseBitShift()
+		left := p.operand(expr, op)
+		right, err := p.required(p.parseBitShift())
 		if err != nil {
 			return nil, err
 		}
 
-		expr = ast.BinaryExpr{Left: expr, Op: op, Right: right}
+		expr = &ast.BinaryExpr{Left: left, Op: op, Right: right, Span: p.span(start)}
 	}
 
 	return expr, nil
@@ -511,6 +951,8 @@ func (p *Parser) parseComparison() (ast.Expr, error) {
 
 // BitShift -> Term ( ( ">>" || "<<" ) Term )*
 func (p *Parser) parseBitShift() (ast.Expr, error) {
+	start := p.here()
+
 	expr, err := p.parseTerm()
 	if err != nil {
 		return nil, err
@@ -519,12 +961,13 @@ func (p *Parser) parseBitShift() (ast.Expr, error) {
 	// ( ( ">>" || "<<" ) Term )*
 	for p.matchToken(token.RightShift) || p.matchToken(token.LeftShift) {
 		op := p.prevToken()
-		right, err := p.parseFactor()
+		left := p.operand(expr, op)
+		right, err := p.required(p.parseFactor())
 		if err != nil {
 			return nil, err
 		}
 
-		expr = ast.BinaryExpr{Left: expr, Op: op, Right: right}
+		expr = &ast.BinaryExpr{Left: left, Op: op, Right: right, Span: p.span(start)}
 	}
 
 	return expr, nil
@@ -532,6 +975,8 @@ func (p *Parser) parseBitShift() (ast.Expr, error) {
 
 // Term -> Factor ( ( "+" | "-" ) Factor )*
 func (p *Parser) parseTerm() (ast.Expr, error) {
+	start := p.here()
+
 	expr, err := p.parseFactor()
 	if err != nil {
 		return nil, err
@@ -540,12 +985,13 @@ func (p *Parser) parseTerm() (ast.Expr, error) {
 	// ( ( "+" | "-" ) Term )*
 	for p.matchToken(token.Add) || p.matchToken(token.Sub) {
 		op := p.prevToken()
-		right, err := p.parseFactor()
+		left := p.operand(expr, op)
+		right, err := p.required(p.parseFactor())
 		if err != nil {
 			return nil, err
 		}
 
-		expr = ast.BinaryExpr{Left: expr, Op: op, Right: right}
+		expr = &ast.BinaryExpr{Left: left, Op: op, Right: right, Span: p.span(start)}
 	}
 
 	return expr, nil
@@ -553,6 +999,8 @@ func (p *Parser) parseTerm() (ast.Expr, error) {
 
 // Factor -> Unary ( ( "*" | "/" | "%" ) Unary )*
 func (p *Parser) parseFactor() (ast.Expr, error) {
+	start := p.here()
+
 	expr, err := p.parseUnary()
 	if err != nil {
 		return nil, err
@@ -561,12 +1009,13 @@ func (p *Parser) parseFactor() (ast.Expr, error) {
 	// ( ( "*" | "/" ) Unary )*
 	for p.matchToken(token.Mul) || p.matchToken(token.Div) || p.matchToken(token.Mod) {
 		op := p.prevToken()
-		right, err := p.parseUnary()
+		left := p.operand(expr, op)
+		right, err := p.required(p.parseUnary())
 		if err != nil {
 			return nil, err
 		}
 
-		expr = ast.BinaryExpr{Left: expr, Op: op, Right: right}
+		expr = &ast.BinaryExpr{Left: left, Op: op, Right: right, Span: p.span(start)}
 	}
 
 	return expr, nil
@@ -582,79 +1031,156 @@ func (p *Parser) parseUnary() (ast.Expr, error) {
 		p.matchToken(token.BitNot) {
 
 		op := p.prevToken()
-		right, err := p.parseUnary()
+		right, err := p.required(p.parseUnary())
 		if err != nil {
 			return nil, err
 		}
 
-		return ast.UnaryExpr{Op: op, Right: right}, nil
+		return &ast.UnaryExpr{Op: op, Right: right, Span: p.span(op.Pos)}, nil
 	}
 
 	return p.parseCall()
 }
 
-// Call -> Primary ( "(" Args? ")" )*
+// Call -> Primary ( "(" Args? ")" | "." Ident | "[" Expr "]" )*
 // Args -> Expression ( "," Expression )*
 func (p *Parser) parseCall() (ast.Expr, error) {
+	start := p.here()
+
 	expr, err := p.parsePrimary()
 	if err != nil {
 		return nil, err
 	}
 
-	if p.matchToken(token.LeftParen) {
-		var args []ast.Expr
-		for ok := true; ok; ok = p.matchToken(token.Comma) {
-			expr, err := p.parseExpr()
+	for {
+		if p.matchToken(token.LeftParen) {
+			expr, err = p.finishCall(expr, start)
+			if err != nil {
+				return nil, err
+			}
+		} else if p.matchToken(token.Dot) {
+			msg := "expected field name after '.'"
+			name, err := p.expectToken(token.Ident, msg)
 			if err != nil {
 				return nil, err
 			}
 
-			if expr != nil {
-				args = append(args, expr)
+			expr = &ast.GetExpr{Object: expr, Name: name, Span: p.span(start)}
+		} else if p.matchToken(token.LeftBracket) {
+			bracket := p.prevToken()
+			index, err := p.required(p.parseExpr())
+			if err != nil {
+				return nil, err
 			}
+
+			msg := "expected ']' after index"
+			if _, err := p.expectToken(token.RightBracket, msg); err != nil {
+				return nil, err
+			}
+
+			expr = &ast.IndexExpr{Object: expr, Bracket: bracket, Index: index, Span: p.span(start)}
+		} else {
+			return expr, nil
 		}
+	}
+}
 
-		msg := "expected ')' after arguments"
-		if _, err := p.expectToken(token.RightParen, msg); err != nil {
+func (p *Parser) finishCall(callee ast.Expr, start token.Pos) (ast.Expr, error) {
+	paren := p.prevToken()
+
+	var args []ast.Expr
+	for ok := true; ok; ok = p.matchToken(token.Comma) {
+		expr, err := p.parseExpr()
+		if err != nil {
 			return nil, err
 		}
 
-		return ast.CallExpr{Name: expr, Args: args}, nil
+		if expr != nil {
+			args = append(args, expr)
+		}
 	}
 
-	return expr, nil
+	msg := "expected ')' after arguments"
+	if _, err := p.expectToken(token.RightParen, msg); err != nil {
+		return nil, err
+	}
+
+	return &ast.CallExpr{Name: callee, Paren: paren, Args: args, Span: p.span(start)}, nil
 }
 
 // Primary -> Ident
-// | Number
+// | StructLiteral
+// | ArrayLiteral
+// | MapLiteral
+// | FnExpr
+// | Int
+// | Float
 // | String
+// | InterpolatedString
 // | "true"
 // | "false"
 // | "null"
 // | "(" Expr ")"
 func (p *Parser) parsePrimary() (ast.Expr, error) {
 	// Primary -> Ident
+	// | StructLiteral
 	if p.matchToken(token.Ident) {
-		return ast.IdentExpr{Name: p.prevToken()}, nil
+		name := p.prevToken()
+		if p.matchToken(token.LeftBrace) {
+			return p.parseStructLiteral(name)
+		}
+
+		return &ast.IdentExpr{Name: name, Span: name.Span()}, nil
 	}
 
-	// Primary -> Number
+	// Primary -> Int
+	// | Float
 	// | String
 	// | "true"
 	// | "false"
 	// | "null"
-	if p.matchToken(token.Number) ||
+	if p.matchToken(token.Int) ||
+		p.matchToken(token.Float) ||
 		p.matchToken(token.String) ||
 		p.matchToken(token.True) ||
 		p.matchToken(token.False) ||
 		p.matchToken(token.Null) {
 
-		return ast.LiteralExpr{Value: p.prevToken()}, nil
+		value := p.prevToken()
+		return &ast.LiteralExpr{Value: value, Span: value.Span()}, nil
+	}
+
+	// Primary -> InterpolatedString
+	if p.matchToken(token.StringHead) {
+		return p.parseInterpolatedString()
+	}
+
+	// Source code the lexer could not read in place of an expression has
+	// already been reported
+	if p.matchToken(token.Illegal) {
+		return &ast.BadExpr{Span: p.prevToken().Span()}, nil
+	}
+
+	// Primary -> ArrayLiteral
+	if p.matchToken(token.LeftBracket) {
+		return p.parseArrayLiteral()
+	}
+
+	// Primary -> MapLiteral
+	// A "{" at the start of a statement begins a BlockStmt instead, see
+	// parseStmt
+	if p.matchToken(token.LeftBrace) {
+		return p.parseMapLiteral()
+	}
+
+	// Primary -> FnExpr
+	if p.matchToken(token.Fn) {
+		return p.parseFnExpr()
 	}
 
 	// Primary -> "(" Expr ")"
 	if p.matchToken(token.LeftParen) {
-		expr, err := p.parseExpr()
+		expr, err := p.required(p.parseExpr())
 		if err != nil {
 			return nil, err
 		}
@@ -673,30 +1199,257 @@ func (p *Parser) parsePrimary() (ast.Expr, error) {
 	return nil, nil
 }
 
+// InterpolatedString -> StringHead Expr ( StringMiddle Expr )* StringTail
+func (p *Parser) parseInterpolatedString() (ast.Expr, error) {
+	head := p.prevToken()
+
+	literals := []token.Token{head}
+	var exprs []ast.Expr
+	for {
+		expr, err := p.required(p.parseExpr())
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+
+		if p.matchToken(token.StringTail) {
+			break
+		}
+
+		msg := "expected '}' after interpolated expression"
+		middle, err := p.expectToken(token.StringMiddle, msg)
+		if err != nil {
+			return nil, err
+		}
+		literals = append(literals, middle)
+	}
+	literals = append(literals, p.prevToken())
+
+	return &ast.InterpolatedString{Literals: literals, Exprs: exprs, Span: p.span(head.Pos)}, nil
+}
+
+// ArrayLiteral -> "[" ( Expr ( "," Expr )* ","? )? "]"
+func (p *Parser) parseArrayLiteral() (ast.Expr, error) {
+	bracket := p.prevToken()
+
+	var elements []ast.Expr
+	for !p.checkToken(token.RightBracket) {
+		expr, err := p.required(p.parseExpr())
+		if err != nil {
+			return nil, err
+		}
+
+		elements = append(elements, expr)
+		if !p.matchToken(token.Comma) {
+			break
+		}
+	}
+
+	msg := "expected ']' after array elements"
+	if _, err := p.expectToken(token.RightBracket, msg); err != nil {
+		return nil, err
+	}
+
+	return &ast.ArrayLiteral{Bracket: bracket, Elements: elements, Span: p.span(bracket.Pos)}, nil
+}
+
+// FnExpr -> "fn" "(" Params? ")" Result Stmt
+func (p *Parser) parseFnExpr() (ast.Expr, error) {
+	keyword := p.prevToken()
+
+	msg := "expected '(' after fn"
+	if _, err := p.expectToken(token.LeftParen, msg); err != nil {
+		return nil, err
+	}
+
+	params, types, err := p.parseParams()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := p.parseResult()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := p.parseStmt()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.FnExpr{
+		Keyword:    keyword,
+		Params:     params,
+		ParamTypes: types,
+		Result:     result,
+		Body:       stmt,
+		Span:       p.span(keyword.Pos),
+	}, nil
+}
+
+// MapLiteral -> "{" ( Expr ":" Expr ( "," Expr ":" Expr )* )? "}"
+func (p *Parser) parseMapLiteral() (ast.Expr, error) {
+	brace := p.prevToken()
+
+	var keys []ast.Expr
+	var values []ast.Expr
+
+	if !p.checkToken(token.RightBrace) {
+		for ok := true; ok; ok = p.matchToken(token.Comma) {
+			key, err := p.required(p.parseExpr())
+			if err != nil {
+				return nil, err
+			}
+
+			msg := "expected ':' after map key"
+			if _, err := p.expectToken(token.Colon, msg); err != nil {
+				return nil, err
+			}
+
+			value, err := p.required(p.parseExpr())
+			if err != nil {
+				return nil, err
+			}
+
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+	}
+
+	msg := "expected '}' after map entries"
+	if _, err := p.expectToken(token.RightBrace, msg); err != nil {
+		return nil, err
+	}
+
+	return &ast.MapLiteral{Brace: brace, Keys: keys, Values: values, Span: p.span(brace.Pos)}, nil
+}
+
+// StructLiteral -> Ident "{" FieldInits? "}"
+// FieldInits -> Ident ":" Expr ( "," Ident ":" Expr )*
+func (p *Parser) parseStructLiteral(name token.Token) (ast.Expr, error) {
+	var fields []token.Token
+	var values []ast.Expr
+
+	// FieldInits?
+	if !p.checkToken(token.RightBrace) {
+		for ok := true; ok; ok = p.matchToken(token.Comma) {
+			msg := "expected field name"
+			field, err := p.expectToken(token.Ident, msg)
+			if err != nil {
+				return nil, err
+			}
+
+			// The duplicate is recorded without stopping, as the rest of
+			// the literal still parses
+			for _, prev := range fields {
+				if prev.Literal == field.Literal {
+					p.record(diag.New(diag.Syntax, field.Span(), "duplicate field '%s'", field.Literal))
+					break
+				}
+			}
+
+			msg = "expected ':' after field name"
+			if _, err := p.expectToken(token.Colon, msg); err != nil {
+				return nil, err
+			}
+
+			value, err := p.required(p.parseExpr())
+			if err != nil {
+				return nil, err
+			}
+
+			fields = append(fields, field)
+			values = append(values, value)
+		}
+	}
+
+	msg := "expected '}' after struct fields"
+	if _, err := p.expectToken(token.RightBrace, msg); err != nil {
+		return nil, err
+	}
+
+	return &ast.StructLiteral{
+		Name:   name,
+		Fields: fields,
+		Values: values,
+		Span:   p.span(name.Pos),
+	}, nil
+}
+
+// required returns the result of parsing an expression that cannot be left
+// out. If it is missing, the error is recorded and a BadExpr is returned in
+// its place so that parsing can continue.
+func (p *Parser) required(expr ast.Expr, err error) (ast.Expr, error) {
+	if err != nil || expr != nil {
+		return expr, err
+	}
+
+	cur := p.peek()
+	p.record(diag.New(diag.Syntax, cur.Span(), "expected expression"))
+	return &ast.BadExpr{Span: token.Span{Start: cur.Pos, End: cur.Pos}}, nil
+}
+
+// operand returns the left operand of a binary operator, which like the
+// right operand cannot be left out.
+func (p *Parser) operand(expr ast.Expr, op token.Token) ast.Expr {
+	if expr != nil {
+		return expr
+	}
+
+	p.record(diag.New(diag.Syntax, op.Span(), "expected expression before '%s'", op.Literal))
+	return &ast.BadExpr{Span: token.Span{Start: op.Pos, End: op.Pos}}
+}
+
 func (p *Parser) checkToken(tok token.TokenType) bool {
-	return p.tokens[p.pos].Type == tok
+	return p.peek().Type == tok
+}
+
+func (p *Parser) checkNext(tok token.TokenType) bool {
+	return p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].Type == tok
 }
 
 func (p *Parser) matchToken(tok token.TokenType) bool {
 	if p.checkToken(tok) {
-		p.pos++
+		p.advance()
 		return true
 	}
 
 	return false
 }
 
+// advance consumes the next token unless it is the final Eof.
+func (p *Parser) advance() {
+	if p.pos < len(p.tokens)-1 {
+		p.pos++
+	}
+}
+
+func (p *Parser) peek() token.Token {
+	return p.tokens[p.pos]
+}
+
+// prevToken returns the last token consumed, or the first token if none
+// have been.
 func (p *Parser) prevToken() token.Token {
-	return p.tokens[p.pos-1]
+	return p.tokens[max(p.pos-1, 0)]
 }
 
 func (p *Parser) expectToken(tok token.TokenType, msg string) (token.Token, error) {
-	cur := p.tokens[p.pos]
+	cur := p.peek()
 
 	if !p.matchToken(tok) {
-		msg := fmt.Sprintf("%s on line %d", msg, cur.Line)
-		return cur, errors.New(msg)
+		return cur, diag.New(diag.Syntax, cur.Span(), "%s", msg)
 	}
 
 	return cur, nil
 }
+
+// here returns the position of the next token.
+func (p *Parser) here() token.Pos {
+	return p.peek().Pos
+}
+
+// span returns the span from start to the end of the last token consumed.
+func (p *Parser) span(start token.Pos) token.Span {
+	return token.Span{Start: start, End: p.prevToken().End}
+}
@@ -0,0 +1,61 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"blorbo/pkg/lexer"
+	"blorbo/pkg/token"
+)
+
+var seeds = []string{
+	"",
+	"x;",
+	"x",
+	"fn",
+	"fn f(",
+	"fn f(a: int, b): {",
+	"if (",
+	"for (;;",
+	"var x = ",
+	"a.b[c](d) = ",
+	"a.b[c] += d++ - e--",
+	"1++ += 2",
+	"{1: 2",
+	"[1, 2",
+	"Point { x: 1",
+	"import \"",
+	"\"a${b}c${",
+	"\"a${b c}\"",
+	"\"${}\"",
+	"} } ) ]",
+	"@ 1 + ;",
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	examples, _ := filepath.Glob("../../examples/*.bb")
+	for _, example := range examples {
+		src, err := os.ReadFile(example)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(string(src))
+	}
+
+	f.Fuzz(func(t *testing.T, src string) {
+		tokens, _ := lexer.New(src).Scan()
+		if program, _ := New(tokens).Parse(); program == nil {
+			t.Fatalf("no program parsed from %q", src)
+		}
+
+		// The parser must not rely on the lexer to end the tokens with Eof
+		if len(tokens) > 0 && tokens[len(tokens)-1].Type == token.Eof {
+			New(tokens[:len(tokens)-1]).Parse()
+		}
+	})
+}
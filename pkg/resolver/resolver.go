@@ -0,0 +1,429 @@
+package resolver
+
+import (
+	"io"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/diag"
+	"blorbo/pkg/object"
+	"blorbo/pkg/token"
+)
+
+type Kind int
+
+const (
+	Var Kind = iota
+	Fn
+	Struct
+	Param
+	Builtin
+	Import
+)
+
+// Decl is a declared name.
+type Decl struct {
+	Kind Kind
+	Name token.Token
+
+	// Node is the declaring *ast.VarStmt, *ast.FnStmt, *ast.StructStmt or
+	// *ast.ImportStmt. For parameters it is the *ast.FnStmt or *ast.FnExpr
+	// declaring them, and for builtins it is nil.
+	Node ast.Node
+
+	// Depth is the depth of the scope the name is declared in, where globals
+	// have depth 0. Slot is the index of the name among the declarations of
+	// that scope, in order of declaration, so the Slot of a parameter is its
+	// position. They describe the scopes of the source; the engines do not
+	// use them, and the compiler numbers the locals of each function itself.
+	Depth int
+	Slot  int
+}
+
+// Binding links a use of a name to its declaration.
+type Binding struct {
+	Decl *Decl
+
+	// Hops is the number of scopes between the use and the declaration, so
+	// a use in the declaring scope itself has 0 hops.
+	Hops int
+}
+
+type Result struct {
+	// Bindings maps each *ast.IdentExpr, *ast.AssignExpr and
+	// *ast.StructLiteral to the declaration of the name it uses.
+	Bindings map[ast.Expr]*Binding
+
+	// Scopes holds every scope in the program in the order they begin,
+	// starting with the global scope.
+	Scopes []*Scope
+}
+
+// Scope is the code from Span where the names in Decls can be used, each
+// from its declaration on. The global scope covers the whole program and
+// has an empty Span.
+type Scope struct {
+	Span  token.Span
+	Decls []*Decl
+}
+
+// Contains reports whether the scope covers the source code at offset.
+func (s *Scope) Contains(offset int) bool {
+	if s.Span == (token.Span{}) {
+		return true
+	}
+
+	return s.Span.Start.Offset <= offset && offset < s.Span.End.Offset
+}
+
+type scope struct {
+	*Scope
+
+	names map[string]*Decl
+	slots int
+}
+
+type Resolver struct {
+	scopes  []*scope
+	fnDepth int
+
+	// loops holds the labels of the loops enclosing the statement being
+	// resolved in the current function, with an empty Literal for loops
+	// without one
+	loops []token.Token
+
+	// pending holds the declarations of every top-level statement, so that
+	// functions can refer to globals declared after them
+	pending map[ast.Node]*Decl
+	defined map[string]bool
+
+	// globals are declared before the program, after the builtins
+	globals []*Decl
+
+	result *Result
+	diags  diag.List
+}
+
+// SetGlobals sets names that are declared before the program, as the
+// globals of earlier input are in an interactive session. The program may
+// redeclare them.
+func (r *Resolver) SetGlobals(globals []*Decl) {
+	r.globals = globals
+}
+
+func New() *Resolver {
+	return &Resolver{
+		pending: make(map[ast.Node]*Decl),
+		defined: make(map[string]bool),
+		result:  &Result{Bindings: make(map[ast.Expr]*Binding)},
+	}
+}
+
+// Resolve binds every name in program to its declaration, reporting
+// undefined names, duplicate declarations in the same scope, return
+// statements outside of a function or imports inside one, and break and
+// continue statements outside of a loop.
+func (r *Resolver) Resolve(program *ast.Program) (*Result, error) {
+	r.beginScope(token.Span{})
+
+	for _, builtin := range object.Builtins(io.Discard) {
+		decl := r.declareIn(r.scopes[0], Builtin, token.New(token.Ident, builtin.Name, 0), nil)
+		r.defined[decl.Name.Literal] = true
+	}
+
+	global := r.scopes[0]
+	for _, decl := range r.globals {
+		global.names[decl.Name.Literal] = decl
+		global.Decls = append(global.Decls, decl)
+		r.defined[decl.Name.Literal] = true
+	}
+
+	for _, stmt := range program.Stmts {
+		switch stmt := stmt.(type) {
+		case *ast.VarStmt:
+			r.declareGlobal(Var, stmt.Name, stmt)
+		case *ast.FnStmt:
+			r.declareGlobal(Fn, stmt.Name, stmt)
+		case *ast.StructStmt:
+			r.declareGlobal(Struct, stmt.Name, stmt)
+		case *ast.ImportStmt:
+			r.declareGlobal(Import, stmt.Name, stmt)
+		}
+	}
+
+	r.resolveStmts(program.Stmts)
+	r.endScope()
+
+	return r.result, r.diags.Err()
+}
+
+func (r *Resolver) resolveStmts(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		r.resolveStmt(stmt)
+	}
+}
+
+func (r *Resolver) resolveStmt(stmt ast.Stmt) {
+	switch stmt := stmt.(type) {
+	case *ast.BlockStmt:
+		r.beginScope(stmt.Span)
+		r.resolveStmts(stmt.Body)
+		r.endScope()
+	case *ast.IfStmt:
+		r.resolveExpr(stmt.Cond)
+		r.resolveBody(stmt.If)
+		if stmt.Else != nil {
+			r.resolveBody(stmt.Else)
+		}
+	case *ast.WhileStmt:
+		r.resolveExpr(stmt.Cond)
+		r.beginLoop(stmt.Label)
+		r.resolveBody(stmt.Body)
+		r.endLoop()
+	case *ast.ForStmt:
+		r.beginScope(stmt.Span)
+		if stmt.Init != nil {
+			r.resolveStmt(stmt.Init)
+		}
+		r.resolveExpr(stmt.Cond)
+		r.resolveExpr(stmt.Inc)
+		r.beginLoop(stmt.Label)
+		r.resolveBody(stmt.Body)
+		r.endLoop()
+		r.endScope()
+	case *ast.StructStmt:
+		r.declare(Struct, stmt.Name, stmt)
+	case *ast.FnStmt:
+		// Functions are declared before their body so they can recurse
+		r.declare(Fn, stmt.Name, stmt)
+		r.resolveFunction(stmt, stmt.Params, stmt.Body)
+	case *ast.VarStmt:
+		// As with FnStmt, a variable initialized to a function is declared
+		// first so that the function can refer to itself
+		if _, ok := stmt.Value.(*ast.FnExpr); ok {
+			r.declare(Var, stmt.Name, stmt)
+			r.resolveExpr(stmt.Value)
+		} else {
+			r.resolveExpr(stmt.Value)
+			r.declare(Var, stmt.Name, stmt)
+		}
+	case *ast.ReturnStmt:
+		if r.fnDepth == 0 {
+			r.errorf(diag.InvalidReturn, stmt.Keyword.Span(), "return outside of function")
+		}
+		r.resolveExpr(stmt.Value)
+	case *ast.ImportStmt:
+		if len(r.scopes) > 1 {
+			r.errorf(diag.InvalidImport, stmt.Span, "import must be at the top level")
+			return
+		}
+		r.declare(Import, stmt.Name, stmt)
+	case *ast.BreakStmt:
+		r.branch(stmt.Keyword, stmt.Label)
+	case *ast.ContinueStmt:
+		r.branch(stmt.Keyword, stmt.Label)
+	case *ast.ExprStmt:
+		r.resolveExpr(stmt.Value)
+	}
+}
+
+func (r *Resolver) resolveFunction(node ast.Node, params []token.Token, body ast.Stmt) {
+	r.fnDepth++
+	r.beginScope(token.Span{Start: node.Pos(), End: node.End()})
+
+	// A break or continue cannot leave the function
+	loops := r.loops
+	r.loops = nil
+
+	for _, param := range params {
+		r.declare(Param, param, node)
+	}
+	r.resolveStmt(body)
+
+	r.loops = loops
+	r.endScope()
+	r.fnDepth--
+}
+
+func (r *Resolver) beginLoop(label token.Token) {
+	if label.Literal != "" {
+		for _, outer := range r.loops {
+			if outer.Literal == label.Literal {
+				r.errorf(diag.Redeclared, label.Span(), "label '%s' is already used by an enclosing loop", label.Literal)
+				break
+			}
+		}
+	}
+
+	r.loops = append(r.loops, label)
+}
+
+func (r *Resolver) endLoop() {
+	r.loops = r.loops[:len(r.loops)-1]
+}
+
+// branch checks that a break or continue statement is in a loop, and in a
+// loop with its label if it has one.
+func (r *Resolver) branch(keyword token.Token, label token.Token) {
+	if len(r.loops) == 0 {
+		r.errorf(diag.InvalidBranch, keyword.Span(), "%s outside of loop", keyword.Literal)
+		return
+	}
+
+	if label.Literal == "" {
+		return
+	}
+
+	for _, loop := range r.loops {
+		if loop.Literal == label.Literal {
+			return
+		}
+	}
+	r.errorf(diag.Undefined, label.Span(), "undefined label '%s'", label.Literal)
+}
+
+func (r *Resolver) resolveExpr(expr ast.Expr) {
+	switch expr := expr.(type) {
+	case *ast.AssignExpr:
+		r.resolveExpr(expr.Value)
+		r.bind(expr, expr.Name)
+	case *ast.BinaryExpr:
+		r.resolveExpr(expr.Left)
+		r.resolveExpr(expr.Right)
+	case *ast.UnaryExpr:
+		r.resolveExpr(expr.Right)
+	case *ast.CallExpr:
+		r.resolveExpr(expr.Name)
+		for _, arg := range expr.Args {
+			r.resolveExpr(arg)
+		}
+	case *ast.GetExpr:
+		r.resolveExpr(expr.Object)
+	case *ast.SetExpr:
+		r.resolveExpr(expr.Object)
+		r.resolveExpr(expr.Value)
+	case *ast.IndexExpr:
+		r.resolveExpr(expr.Object)
+		r.resolveExpr(expr.Index)
+	case *ast.SetIndexExpr:
+		r.resolveExpr(expr.Object)
+		r.resolveExpr(expr.Index)
+		r.resolveExpr(expr.Value)
+	case *ast.ArrayLiteral:
+		for _, element := range expr.Elements {
+			r.resolveExpr(element)
+		}
+	case *ast.MapLiteral:
+		for n, key := range expr.Keys {
+			r.resolveExpr(key)
+			r.resolveExpr(expr.Values[n])
+		}
+	case *ast.FnExpr:
+		r.resolveFunction(expr, expr.Params, expr.Body)
+	case *ast.StructLiteral:
+		r.bind(expr, expr.Name)
+		for _, value := range expr.Values {
+			r.resolveExpr(value)
+		}
+	case *ast.InterpolatedString:
+		for _, part := range expr.Exprs {
+			r.resolveExpr(part)
+		}
+	case *ast.IdentExpr:
+		r.bind(expr, expr.Name)
+	}
+}
+
+// resolveBody resolves the body of an if, while or for statement. As in the
+// engines, a body that is not a block is still a scope of its own.
+func (r *Resolver) resolveBody(stmt ast.Stmt) {
+	if _, ok := stmt.(*ast.BlockStmt); ok {
+		r.resolveStmt(stmt)
+		return
+	}
+
+	r.beginScope(token.Span{Start: stmt.Pos(), End: stmt.End()})
+	r.resolveStmt(stmt)
+	r.endScope()
+}
+
+func (r *Resolver) beginScope(span token.Span) {
+	s := &scope{Scope: &Scope{Span: span}, names: make(map[string]*Decl)}
+	r.scopes = append(r.scopes, s)
+	r.result.Scopes = append(r.result.Scopes, s.Scope)
+}
+
+func (r *Resolver) endScope() {
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+// declareGlobal records a top-level declaration ahead of resolving the
+// program. Redeclaring a global is allowed and replaces it from that point.
+func (r *Resolver) declareGlobal(kind Kind, name token.Token, node ast.Node) {
+	global := r.scopes[0]
+
+	decl := &Decl{Kind: kind, Name: name, Node: node, Slot: global.slots}
+	global.slots++
+	global.Decls = append(global.Decls, decl)
+	r.pending[node] = decl
+
+	if _, ok := global.names[name.Literal]; !ok {
+		global.names[name.Literal] = decl
+	}
+}
+
+func (r *Resolver) declare(kind Kind, name token.Token, node ast.Node) {
+	if len(r.scopes) == 1 {
+		decl := r.pending[node]
+		r.scopes[0].names[name.Literal] = decl
+		r.defined[name.Literal] = true
+		return
+	}
+
+	s := r.scopes[len(r.scopes)-1]
+	if _, ok := s.names[name.Literal]; ok {
+		r.errorf(diag.Redeclared, name.Span(), "'%s' is already declared in this scope", name.Literal)
+		return
+	}
+
+	r.declareIn(s, kind, name, node)
+}
+
+func (r *Resolver) declareIn(s *scope, kind Kind, name token.Token, node ast.Node) *Decl {
+	decl := &Decl{
+		Kind:  kind,
+		Name:  name,
+		Node:  node,
+		Depth: len(r.scopes) - 1,
+		Slot:  s.slots,
+	}
+
+	s.names[name.Literal] = decl
+	s.Decls = append(s.Decls, decl)
+	s.slots++
+	return decl
+}
+
+func (r *Resolver) bind(expr ast.Expr, name token.Token) {
+	for n := len(r.scopes) - 1; n >= 0; n-- {
+		decl, ok := r.scopes[n].names[name.Literal]
+		if !ok {
+			continue
+		}
+
+		// Top-level code runs in order, so it cannot use a global before
+		// the global is declared. Functions run later and can.
+		if n == 0 && r.fnDepth == 0 && !r.defined[name.Literal] {
+			r.errorf(diag.UsedBeforeDecl, name.Span(), "variable '%s' used before declaration", name.Literal)
+			return
+		}
+
+		r.result.Bindings[expr] = &Binding{Decl: decl, Hops: len(r.scopes) - 1 - n}
+		return
+	}
+
+	r.errorf(diag.Undefined, name.Span(), "undefined variable '%s'", name.Literal)
+}
+
+func (r *Resolver) errorf(code diag.Code, span token.Span, format string, args ...interface{}) {
+	r.diags = append(r.diags, diag.New(code, span, format, args...))
+}
@@ -0,0 +1,26 @@
+package resolver
+
+import (
+	"testing"
+
+	"blorbo/pkg/diag"
+	"blorbo/pkg/internal/golden"
+	"blorbo/pkg/lexer"
+	"blorbo/pkg/parser"
+)
+
+// TestGolden resolves each file in testdata and compares the errors reported
+// with the .golden file next to it.
+func TestGolden(t *testing.T) {
+	golden.Run(t, func(t *testing.T, file string, src string) string {
+		tokens, _ := lexer.New(src).Scan()
+		program, err := parser.New(tokens).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = New().Resolve(program)
+		diag.SetFile(err, file)
+		return golden.Errors(err)
+	})
+}
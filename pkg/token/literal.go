@@ -0,0 +1,55 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseInt returns the value of an Int literal. Digits may be separated by
+// "_". A hex, octal or binary literal, written with a 0x, 0o or 0b prefix,
+// may use all 64 bits, so that 0xffffffffffffffff is -1, while a decimal
+// literal must fit in an int64.
+func ParseInt(literal string) (int64, error) {
+	digits := strings.ReplaceAll(literal, "_", "")
+
+	if len(digits) > 2 && digits[0] == '0' {
+		if base, ok := bases[digits[1]]; ok {
+			value, err := strconv.ParseUint(digits[2:], base, 64)
+			if err != nil {
+				return 0, numberError(literal, err)
+			}
+			return int64(value), nil
+		}
+	}
+
+	value, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, numberError(literal, err)
+	}
+	return value, nil
+}
+
+// ParseFloat returns the value of a Float literal, which like an Int literal
+// may separate digits with "_".
+func ParseFloat(literal string) (float64, error) {
+	digits := strings.ReplaceAll(literal, "_", "")
+
+	value, err := strconv.ParseFloat(digits, 64)
+	if err != nil || math.IsInf(value, 0) {
+		return 0, numberError(literal, err)
+	}
+	return value, nil
+}
+
+var bases = map[byte]int{'x': 16, 'X': 16, 'o': 8, 'O': 8, 'b': 2, 'B': 2}
+
+func numberError(literal string, err error) error {
+	if errors.Is(err, strconv.ErrRange) {
+		return errors.New(fmt.Sprintf("number '%s' out of range", literal))
+	}
+
+	return errors.New(fmt.Sprintf("invalid number '%s'", literal))
+}
@@ -6,14 +6,20 @@ const (
 	// Eof
 	Eof TokenType = "Eof"
 
+	// Illegal is source code that is not a valid token
+	Illegal TokenType = "Illegal"
+
 	// Delimiters
-	LeftParen  TokenType = "LeftParen"  // (
-	RightParen TokenType = "RightParen" // )
-	LeftBrace  TokenType = "LeftBrace"  // {
-	RightBrace TokenType = "RightBrace" // }
-	Dot        TokenType = "Dot"        // .
-	Comma      TokenType = "Comma"      // ,
-	Semicolon  TokenType = "Semicolon"  // ;
+	LeftParen    TokenType = "LeftParen"    // (
+	RightParen   TokenType = "RightParen"   // )
+	LeftBrace    TokenType = "LeftBrace"    // {
+	RightBrace   TokenType = "RightBrace"   // }
+	LeftBracket  TokenType = "LeftBracket"  // [
+	RightBracket TokenType = "RightBracket" // ]
+	Dot          TokenType = "Dot"          // .
+	Comma        TokenType = "Comma"        // ,
+	Colon        TokenType = "Colon"        // :
+	Semicolon    TokenType = "Semicolon"    // ;
 
 	// Mathematical operations
 	Mul TokenType = "Mul" // *
@@ -22,8 +28,22 @@ const (
 	Add TokenType = "Add" // +
 	Sub TokenType = "Sub" // -
 
+	// Assignment operations
+	Assign           TokenType = "Assign"           // =
+	AddAssign        TokenType = "AddAssign"        // +=
+	SubAssign        TokenType = "SubAssign"        // -=
+	MulAssign        TokenType = "MulAssign"        // *=
+	DivAssign        TokenType = "DivAssign"        // /=
+	ModAssign        TokenType = "ModAssign"        // %=
+	BitAndAssign     TokenType = "BitAndAssign"     // &=
+	BitOrAssign      TokenType = "BitOrAssign"      // |=
+	BitXorAssign     TokenType = "BitXorAssign"     // ^=
+	LeftShiftAssign  TokenType = "LeftShiftAssign"  // <<=
+	RightShiftAssign TokenType = "RightShiftAssign" // >>=
+	Increment        TokenType = "Increment"        // ++
+	Decrement        TokenType = "Decrement"        // --
+
 	// Logical operations
-	Assign       TokenType = "Assign"       // =
 	Equal        TokenType = "Equal"        // ==
 	Not          TokenType = "Not"          // !
 	NotEqual     TokenType = "NotEqual"     // !=
@@ -42,32 +62,88 @@ const (
 
 	// Literals
 	Ident   TokenType = "Ident"
-	Number  TokenType = "Number"
+	Int     TokenType = "Int"
+	Float   TokenType = "Float"
 	String  TokenType = "String"
 	Comment TokenType = "Comment"
 
+	// The parts of an interpolated string such as "a${b}c${d}e": StringHead
+	// is `"a${`, StringMiddle is `}c${` and StringTail is `}e"`. Like a
+	// String, their literals hold the decoded text, here "a", "c" and "e"
+	StringHead   TokenType = "StringHead"
+	StringMiddle TokenType = "StringMiddle"
+	StringTail   TokenType = "StringTail"
+
 	// Keywords
-	Var    TokenType = "Var"    // var
-	Return TokenType = "Return" // return
-	Fn     TokenType = "Fn"     // fn
-	Struct TokenType = "Struct" // struct
-	For    TokenType = "For"    // for
-	While  TokenType = "While"  // while
-	If     TokenType = "If"     // if
-	Else   TokenType = "Else"   // else
-	Null   TokenType = "Null"   // null
-	True   TokenType = "True"   // true
-	False  TokenType = "False"  // false
-	And    TokenType = "And"    // and
-	Or     TokenType = "Or"     // or
+	Var      TokenType = "Var"      // var
+	Return   TokenType = "Return"   // return
+	Fn       TokenType = "Fn"       // fn
+	Struct   TokenType = "Struct"   // struct
+	For      TokenType = "For"      // for
+	While    TokenType = "While"    // while
+	If       TokenType = "If"       // if
+	Else     TokenType = "Else"     // else
+	Null     TokenType = "Null"     // null
+	True     TokenType = "True"     // true
+	False    TokenType = "False"    // false
+	And      TokenType = "And"      // and
+	Or       TokenType = "Or"       // or
+	Import   TokenType = "Import"   // import
+	Break    TokenType = "Break"    // break
+	Continue TokenType = "Continue" // continue
 )
 
+// Pos is a position in source code. Line and Column count from 1, with
+// columns counted in bytes, and Offset is the byte offset from 0.
+type Pos struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// Span is the range of source code from Start up to but not including End.
+type Span struct {
+	Start Pos
+	End   Pos
+}
+
+// Token is a token of source code. Pos is the position of its first byte and
+// End the position just past its last.
 type Token struct {
 	Type    TokenType
 	Literal string
-	Line    int
+	Pos
+	End Pos
 }
 
+// New returns a token that only knows the line it is on, for tokens that do
+// not come from source code.
 func New(ty TokenType, literal string, line int) Token {
-	return Token{Type: ty, Literal: literal, Line: line}
+	return Token{Type: ty, Literal: literal, Pos: Pos{Line: line}}
+}
+
+func (t Token) Span() Span {
+	return Span{Start: t.Pos, End: t.End}
+}
+
+var compound = map[TokenType]TokenType{
+	AddAssign:        Add,
+	SubAssign:        Sub,
+	MulAssign:        Mul,
+	DivAssign:        Div,
+	ModAssign:        Mod,
+	BitAndAssign:     BitAnd,
+	BitOrAssign:      BitOr,
+	BitXorAssign:     BitXor,
+	LeftShiftAssign:  LeftShift,
+	RightShiftAssign: RightShift,
+	Increment:        Add,
+	Decrement:        Sub,
+}
+
+// Compound returns the binary operator applied by a compound assignment
+// operator, such as Add for += and ++, and false for any other token type.
+func Compound(ty TokenType) (TokenType, bool) {
+	op, ok := compound[ty]
+	return op, ok
 }
@@ -0,0 +1,531 @@
+package types
+
+import (
+	"blorbo/pkg/ast"
+	"blorbo/pkg/diag"
+	"blorbo/pkg/resolver"
+	"blorbo/pkg/token"
+)
+
+// Checker infers the types of expressions and reports uses of values that
+// do not match their annotations. Code without annotations is gradually
+// typed: the checker only reports an error when it knows the types involved.
+type Checker struct {
+	bindings map[ast.Expr]*resolver.Binding
+	structs  map[string]bool
+
+	// results holds the result types of the enclosing functions
+	results []Type
+
+	diags diag.List
+}
+
+// New creates a checker using the bindings found by the resolver.
+func New(resolved *resolver.Result) *Checker {
+	c := &Checker{
+		bindings: resolved.Bindings,
+		structs:  make(map[string]bool),
+	}
+
+	// Structs declared at the top level can be named before their
+	// declaration, as can those declared before the program
+	if len(resolved.Scopes) > 0 {
+		for _, decl := range resolved.Scopes[0].Decls {
+			if decl.Kind == resolver.Struct {
+				c.structs[decl.Name.Literal] = true
+			}
+		}
+	}
+
+	return c
+}
+
+func (c *Checker) Check(program *ast.Program) error {
+	c.checkStmts(program.Stmts)
+	return c.diags.Err()
+}
+
+func (c *Checker) checkStmts(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		c.checkStmt(stmt)
+	}
+}
+
+func (c *Checker) checkStmt(stmt ast.Stmt) {
+	switch stmt := stmt.(type) {
+	case *ast.BlockStmt:
+		c.checkStmts(stmt.Body)
+	case *ast.IfStmt:
+		c.check(stmt.Cond)
+		c.checkStmt(stmt.If)
+		if stmt.Else != nil {
+			c.checkStmt(stmt.Else)
+		}
+	case *ast.WhileStmt:
+		c.check(stmt.Cond)
+		c.checkStmt(stmt.Body)
+	case *ast.ForStmt:
+		if stmt.Init != nil {
+			c.checkStmt(stmt.Init)
+		}
+		c.check(stmt.Cond)
+		c.check(stmt.Inc)
+		c.checkStmt(stmt.Body)
+	case *ast.StructStmt:
+		c.structs[stmt.Name.Literal] = true
+	case *ast.FnStmt:
+		c.checkFunction(stmt.ParamTypes, stmt.Result, stmt.Body)
+	case *ast.VarStmt:
+		want := c.annotation(stmt.Type)
+		if stmt.Value == nil {
+			return
+		}
+
+		got := c.check(stmt.Value)
+		if !Assignable(want, got) {
+			c.errorf(
+				diag.TypeMismatch, stmt.Span, "cannot use %s as %s in declaration of '%s'",
+				got, want, stmt.Name.Literal,
+			)
+		}
+	case *ast.ReturnStmt:
+		got := Null
+		if stmt.Value != nil {
+			got = c.check(stmt.Value)
+		}
+
+		if len(c.results) == 0 {
+			return
+		}
+
+		want := c.results[len(c.results)-1]
+		if !Assignable(want, got) {
+			c.errorf(diag.TypeMismatch, stmt.Span, "cannot return %s from function returning %s", got, want)
+		}
+	case *ast.ExprStmt:
+		c.check(stmt.Value)
+	}
+}
+
+func (c *Checker) checkFunction(params []*ast.TypeExpr, result *ast.TypeExpr, body ast.Stmt) {
+	for _, param := range params {
+		c.annotation(param)
+	}
+
+	want := c.annotation(result)
+	c.results = append(c.results, want)
+	c.checkStmt(body)
+	c.results = c.results[:len(c.results)-1]
+
+	// Falling off the end returns null
+	if !Assignable(want, Null) && !returns(body) {
+		end := body.End()
+		span := token.Span{Start: body.Pos(), End: end}
+		if _, ok := body.(*ast.BlockStmt); ok {
+			// The closing brace
+			span.Start = token.Pos{Offset: end.Offset - 1, Line: end.Line, Column: end.Column - 1}
+		}
+		c.errorf(diag.MissingReturn, span, "missing return in function returning %s", want)
+	}
+}
+
+// returns reports whether a statement always returns or loops forever, so
+// that it never runs on to the statement after it, breaks out of a loop or
+// continues one.
+func returns(stmt ast.Stmt) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.BlockStmt:
+		for _, stmt := range stmt.Body {
+			switch {
+			case returns(stmt):
+				return true
+			case branches(stmt):
+				return false
+			}
+		}
+	case *ast.IfStmt:
+		return stmt.Else != nil && returns(stmt.If) && returns(stmt.Else)
+	case *ast.WhileStmt:
+		return isTrue(stmt.Cond) && !breaks(stmt.Body, stmt.Label, false)
+	case *ast.ForStmt:
+		return (stmt.Cond == nil || isTrue(stmt.Cond)) && !breaks(stmt.Body, stmt.Label, false)
+	}
+
+	return false
+}
+
+// branches reports whether a statement always breaks or continues.
+func branches(stmt ast.Stmt) bool {
+	switch stmt := stmt.(type) {
+	case *ast.BreakStmt, *ast.ContinueStmt:
+		return true
+	case *ast.BlockStmt:
+		for _, stmt := range stmt.Body {
+			if returns(stmt) {
+				return false
+			}
+			if branches(stmt) {
+				return true
+			}
+		}
+	case *ast.IfStmt:
+		return stmt.Else != nil && branches(stmt.If) && branches(stmt.Else)
+	}
+
+	return false
+}
+
+// breaks reports whether stmt, in the body of a loop with the given label,
+// has a break statement that leaves the loop. nested is whether stmt is in
+// an inner loop, which a break without a label leaves instead.
+func breaks(stmt ast.Stmt, label token.Token, nested bool) bool {
+	switch stmt := stmt.(type) {
+	case *ast.BreakStmt:
+		if stmt.Label.Literal == "" {
+			return !nested
+		}
+		return stmt.Label.Literal == label.Literal
+	case *ast.BlockStmt:
+		for _, stmt := range stmt.Body {
+			if breaks(stmt, label, nested) {
+				return true
+			}
+		}
+	case *ast.IfStmt:
+		return breaks(stmt.If, label, nested) || stmt.Else != nil && breaks(stmt.Else, label, nested)
+	case *ast.WhileStmt:
+		return breaks(stmt.Body, label, true)
+	case *ast.ForStmt:
+		return breaks(stmt.Body, label, true)
+	}
+
+	return false
+}
+
+// isTrue reports whether expr is the literal true.
+func isTrue(expr ast.Expr) bool {
+	literal, ok := expr.(*ast.LiteralExpr)
+	return ok && literal.Value.Type == token.True
+}
+
+// check returns the type of an expression, reporting any errors within it.
+func (c *Checker) check(expr ast.Expr) Type {
+	switch expr := expr.(type) {
+	case *ast.AssignExpr:
+		binding, ok := c.bindings[expr]
+
+		target := Any
+		if ok {
+			target = c.declType(binding.Decl)
+		}
+
+		got := c.assigned(expr.Op, expr.Span, target, expr.Value)
+		if !ok {
+			return got
+		}
+
+		// Only variables and parameters carry annotations, so assigning to
+		// a function or struct name is left to the engines
+		kind := binding.Decl.Kind
+		if kind != resolver.Var && kind != resolver.Param {
+			return got
+		}
+
+		want := c.declType(binding.Decl)
+		if !Assignable(want, got) {
+			c.errorf(
+				diag.TypeMismatch, expr.Span, "cannot assign %s to '%s' of type %s",
+				got, expr.Name.Literal, want,
+			)
+		}
+		return got
+	case *ast.BinaryExpr:
+		return c.checkBinary(expr)
+	case *ast.UnaryExpr:
+		return c.checkUnary(expr)
+	case *ast.CallExpr:
+		return c.checkCall(expr)
+	case *ast.GetExpr:
+		c.check(expr.Object)
+		return Any
+	case *ast.SetExpr:
+		c.check(expr.Object)
+		return c.assigned(expr.Op, expr.Span, Any, expr.Value)
+	case *ast.IndexExpr:
+		c.check(expr.Object)
+		c.check(expr.Index)
+		return Any
+	case *ast.SetIndexExpr:
+		c.check(expr.Object)
+		c.check(expr.Index)
+		return c.assigned(expr.Op, expr.Span, Any, expr.Value)
+	case *ast.ArrayLiteral:
+		for _, element := range expr.Elements {
+			c.check(element)
+		}
+		return Array
+	case *ast.MapLiteral:
+		for n, key := range expr.Keys {
+			c.check(key)
+			c.check(expr.Values[n])
+		}
+		return Map
+	case *ast.FnExpr:
+		c.checkFunction(expr.ParamTypes, expr.Result, expr.Body)
+		return Fn
+	case *ast.StructLiteral:
+		for _, value := range expr.Values {
+			c.check(value)
+		}
+		if c.structs[expr.Name.Literal] {
+			return Type(expr.Name.Literal)
+		}
+		return Any
+	case *ast.IdentExpr:
+		if binding, ok := c.bindings[expr]; ok {
+			return c.declType(binding.Decl)
+		}
+		return Any
+	case *ast.LiteralExpr:
+		return literalType(expr.Value)
+	case *ast.InterpolatedString:
+		for _, part := range expr.Exprs {
+			c.check(part)
+		}
+		return String
+	}
+
+	return Any
+}
+
+// assigned returns the type of the value an assignment with the operator op
+// stores in a target of the given type.
+func (c *Checker) assigned(op token.Token, span token.Span, target Type, value ast.Expr) Type {
+	got := c.check(value)
+
+	binary, ok := token.Compound(op.Type)
+	if !ok {
+		return got
+	}
+
+	return c.binary(token.Token{Type: binary, Literal: op.Literal}, span, target, got)
+}
+
+func (c *Checker) checkBinary(expr *ast.BinaryExpr) Type {
+	left := c.check(expr.Left)
+	right := c.check(expr.Right)
+	return c.binary(expr.Op, expr.Span, left, right)
+}
+
+// binary returns the type of the binary operator op applied to operands of
+// the given types, reporting the expression at span if it is invalid.
+func (c *Checker) binary(op token.Token, span token.Span, left, right Type) Type {
+	switch op.Type {
+	case token.Equal, token.NotEqual:
+		return Bool
+	case token.And, token.Or:
+		if left == right {
+			return left
+		}
+		return Any
+	case token.Add:
+		if left == String || right == String {
+			return String
+		}
+		if left == Any || right == Any {
+			// The unknown operand may be a string
+			return Any
+		}
+		return c.arithmetic(op, span, left, right)
+	case token.Sub, token.Mul, token.Div, token.Mod:
+		return c.arithmetic(op, span, left, right)
+	case token.Greater, token.GreaterEqual, token.Less, token.LessEqual:
+		switch {
+		case left == Any && (right == Any || numeric(right) || right == String):
+		case right == Any && (numeric(left) || left == String):
+		case numeric(left) && numeric(right):
+		case left == String && right == String:
+		default:
+			c.operandError(op, span, left, right)
+		}
+		return Bool
+	case token.BitAnd, token.BitOr, token.BitXor, token.LeftShift, token.RightShift:
+		if !Assignable(Int, left) || !Assignable(Int, right) {
+			c.operandError(op, span, left, right)
+		}
+		return Int
+	}
+
+	return Any
+}
+
+func (c *Checker) arithmetic(op token.Token, span token.Span, left, right Type) Type {
+	if (left != Any && !numeric(left)) || (right != Any && !numeric(right)) {
+		c.operandError(op, span, left, right)
+		return Any
+	}
+
+	switch {
+	case left == Int && right == Int:
+		return Int
+	case left == Float || right == Float:
+		return Float
+	}
+
+	return Any
+}
+
+func (c *Checker) checkUnary(expr *ast.UnaryExpr) Type {
+	right := c.check(expr.Right)
+
+	switch expr.Op.Type {
+	case token.Not:
+		return Bool
+	case token.Add, token.Sub:
+		if right == Any || numeric(right) {
+			return right
+		}
+	case token.BitNot:
+		if Assignable(Int, right) {
+			return Int
+		}
+	}
+
+	c.errorf(diag.InvalidOperand, expr.Span, "invalid operand for '%s': %s", expr.Op.Literal, right)
+	return Any
+}
+
+func (c *Checker) checkCall(expr *ast.CallExpr) Type {
+	var sig *Signature
+	if ident, ok := expr.Name.(*ast.IdentExpr); ok {
+		if binding, ok := c.bindings[ident]; ok {
+			sig = c.signature(binding.Decl)
+		}
+	}
+
+	callee := c.check(expr.Name)
+	args := make([]Type, len(expr.Args))
+	for n, arg := range expr.Args {
+		args[n] = c.check(arg)
+	}
+
+	if sig == nil {
+		if callee != Any && callee != Fn {
+			c.errorf(diag.NotCallable, expr.Span, "cannot call %s", callee)
+		}
+		return Any
+	}
+
+	if sig.Variadic {
+		return sig.Result
+	}
+
+	if len(args) != len(sig.Params) {
+		c.errorf(diag.ArgCount, expr.Span, "expected %d arguments but got %d", len(sig.Params), len(args))
+		return sig.Result
+	}
+
+	for n, arg := range args {
+		if !Assignable(sig.Params[n], arg) {
+			c.errorf(
+				diag.TypeMismatch, expr.Span, "cannot use %s as %s in argument %d",
+				arg, sig.Params[n], n+1,
+			)
+		}
+	}
+
+	return sig.Result
+}
+
+// declType returns the type of the values a declared name holds.
+func (c *Checker) declType(decl *resolver.Decl) Type {
+	switch decl.Kind {
+	case resolver.Var:
+		return c.typeOf(decl.Node.(*ast.VarStmt).Type)
+	case resolver.Param:
+		return c.typeOf(paramTypes(decl.Node)[decl.Slot])
+	case resolver.Fn, resolver.Builtin:
+		return Fn
+	case resolver.Struct:
+		return Struct
+	case resolver.Import:
+		return Module
+	}
+
+	return Any
+}
+
+// signature returns the signature of a declared function or builtin, or nil
+// when the name is not one.
+func (c *Checker) signature(decl *resolver.Decl) *Signature {
+	switch decl.Kind {
+	case resolver.Builtin:
+		return builtins[decl.Name.Literal]
+	case resolver.Fn:
+		fn := decl.Node.(*ast.FnStmt)
+
+		sig := &Signature{Result: c.typeOf(fn.Result)}
+		for _, param := range fn.ParamTypes {
+			sig.Params = append(sig.Params, c.typeOf(param))
+		}
+		return sig
+	}
+
+	return nil
+}
+
+// annotation returns the type named by an annotation, reporting an error if
+// it names no type. A missing annotation is Any.
+func (c *Checker) annotation(ty *ast.TypeExpr) Type {
+	t := c.typeOf(ty)
+	if t == Any && ty != nil && ty.Name.Literal != string(Any) {
+		c.errorf(diag.UnknownType, ty.Span, "unknown type '%s'", ty.Name.Literal)
+	}
+
+	return t
+}
+
+// typeOf is like annotation but does not report errors, as it is used where
+// the annotation is referred to rather than declared.
+func (c *Checker) typeOf(ty *ast.TypeExpr) Type {
+	if ty == nil {
+		return Any
+	}
+
+	if t, ok := names[ty.Name.Literal]; ok {
+		return t
+	}
+
+	if !c.structs[ty.Name.Literal] {
+		return Any
+	}
+
+	return Type(ty.Name.Literal)
+}
+
+func literalType(tok token.Token) Type {
+	switch tok.Type {
+	case token.Int:
+		return Int
+	case token.Float:
+		return Float
+	case token.String:
+		return String
+	case token.True, token.False:
+		return Bool
+	case token.Null:
+		return Null
+	}
+
+	return Any
+}
+
+func (c *Checker) operandError(op token.Token, span token.Span, left, right Type) {
+	c.errorf(diag.InvalidOperand, span, "invalid operands for '%s': %s and %s", op.Literal, left, right)
+}
+
+func (c *Checker) errorf(code diag.Code, span token.Span, format string, args ...interface{}) {
+	c.diags = append(c.diags, diag.New(code, span, format, args...))
+}
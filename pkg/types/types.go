@@ -0,0 +1,88 @@
+package types
+
+import (
+	"blorbo/pkg/ast"
+)
+
+// Type is the static type of an expression. An instance of a struct has the
+// struct's name as its type.
+type Type string
+
+const (
+	// Any is the type of every expression whose type is not known, and is
+	// compatible with every other type
+	Any Type = "any"
+
+	Null   Type = "null"
+	Bool   Type = "bool"
+	Int    Type = "int"
+	Float  Type = "float"
+	String Type = "string"
+	Array  Type = "array"
+	Map    Type = "map"
+	Fn     Type = "fn"
+
+	// Struct is the type of a struct declaration itself
+	Struct Type = "struct"
+
+	Module Type = "module"
+)
+
+// names holds the types that can be named in an annotation besides structs.
+var names = map[string]Type{
+	"any":    Any,
+	"null":   Null,
+	"bool":   Bool,
+	"int":    Int,
+	"float":  Float,
+	"string": String,
+	"array":  Array,
+	"map":    Map,
+	"fn":     Fn,
+}
+
+// Signature is the type of a function.
+type Signature struct {
+	Params   []Type
+	Result   Type
+	Variadic bool
+}
+
+var builtins = map[string]*Signature{
+	"println": {Result: Null, Variadic: true},
+	"len":     {Params: []Type{Any}, Result: Int},
+	"push":    {Params: []Type{Array, Any}, Result: Null},
+	"pop":     {Params: []Type{Array}, Result: Any},
+	"keys":    {Params: []Type{Map}, Result: Array},
+	"values":  {Params: []Type{Map}, Result: Array},
+	"has":     {Params: []Type{Map, Any}, Result: Bool},
+	"delete":  {Params: []Type{Map, Any}, Result: Bool},
+	"args":    {Result: Array},
+}
+
+// Builtin returns the signature of the builtin function name, or nil if
+// there is no such builtin.
+func Builtin(name string) *Signature {
+	return builtins[name]
+}
+
+// Assignable reports whether a value of type got can be used where a value of
+// type want is expected.
+func Assignable(want, got Type) bool {
+	return want == Any || got == Any || want == got
+}
+
+func numeric(t Type) bool {
+	return t == Int || t == Float
+}
+
+func paramTypes(node ast.Node) []*ast.TypeExpr {
+	switch node := node.(type) {
+	case *ast.FnStmt:
+		return node.ParamTypes
+	case *ast.FnExpr:
+		return node.ParamTypes
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package vm
+
+import (
+	"blorbo/pkg/compiler"
+	"blorbo/pkg/object"
+)
+
+// Closure is a compiled function together with the variables it captured
+// when it was created. Globals are those of the module that created it, so
+// a function imported from another module still sees its own globals.
+type Closure struct {
+	Fn       *compiler.Function
+	Upvalues []*Upvalue
+	Globals  map[string]object.Value
+}
+
+func (*Closure) Type() object.Type { return object.FunctionType }
+
+func (c *Closure) String() string {
+	return c.Fn.String()
+}
+
+func (c *Closure) NumParams() int {
+	return c.Fn.Arity
+}
+
+// Upvalue is a captured variable. While open it refers to a slot on the
+// stack of the VM; once that slot is popped the upvalue is closed and holds
+// the value itself.
+type Upvalue struct {
+	slot   int
+	open   bool
+	closed object.Value
+}
+
+func (vm *VM) getUpvalue(upvalue *Upvalue) object.Value {
+	if upvalue.open {
+		return vm.stack[upvalue.slot]
+	}
+
+	return upvalue.closed
+}
+
+func (vm *VM) setUpvalue(upvalue *Upvalue, value object.Value) {
+	if upvalue.open {
+		vm.stack[upvalue.slot] = value
+	} else {
+		upvalue.closed = value
+	}
+}
+
+// captureUpvalue returns the open upvalue for a stack slot, creating it if
+// no closure has captured the slot yet.
+func (vm *VM) captureUpvalue(slot int) *Upvalue {
+	for _, upvalue := range vm.openUpvalues {
+		if upvalue.slot == slot {
+			return upvalue
+		}
+	}
+
+	upvalue := &Upvalue{slot: slot, open: true}
+	vm.openUpvalues = append(vm.openUpvalues, upvalue)
+	return upvalue
+}
+
+// closeUpvalues closes every open upvalue referring to slot or above.
+func (vm *VM) closeUpvalues(slot int) {
+	open := vm.openUpvalues[:0]
+	for _, upvalue := range vm.openUpvalues {
+		if upvalue.slot >= slot {
+			upvalue.closed = vm.stack[upvalue.slot]
+			upvalue.open = false
+		} else {
+			open = append(open, upvalue)
+		}
+	}
+
+	vm.openUpvalues = open
+}
@@ -0,0 +1,387 @@
+package vm
+
+import (
+	"io"
+
+	"blorbo/pkg/compiler"
+	"blorbo/pkg/diag"
+	"blorbo/pkg/object"
+	"blorbo/pkg/token"
+)
+
+const maxFrames = 1024
+
+// operators maps the opcode of each operator to the token that object.Binary
+// and object.Unary expect.
+var operators = [...]token.TokenType{
+	compiler.OpMul:          token.Mul,
+	compiler.OpDiv:          token.Div,
+	compiler.OpMod:          token.Mod,
+	compiler.OpAdd:          token.Add,
+	compiler.OpSub:          token.Sub,
+	compiler.OpNeg:          token.Sub,
+	compiler.OpPos:          token.Add,
+	compiler.OpEqual:        token.Equal,
+	compiler.OpNotEqual:     token.NotEqual,
+	compiler.OpNot:          token.Not,
+	compiler.OpGreater:      token.Greater,
+	compiler.OpGreaterEqual: token.GreaterEqual,
+	compiler.OpLess:         token.Less,
+	compiler.OpLessEqual:    token.LessEqual,
+	compiler.OpBitAnd:       token.BitAnd,
+	compiler.OpBitOr:        token.BitOr,
+	compiler.OpBitXor:       token.BitXor,
+	compiler.OpBitNot:       token.BitNot,
+	compiler.OpRightShift:   token.RightShift,
+	compiler.OpLeftShift:    token.LeftShift,
+}
+
+type frame struct {
+	closure *Closure
+	fn      *compiler.Function
+	ip      int
+	base    int
+}
+
+type VM struct {
+	stack        []object.Value
+	frames       []frame
+	globals      map[string]object.Value
+	openUpvalues []*Upvalue
+	importer     object.Importer
+	out          io.Writer
+}
+
+func New(out io.Writer) *VM {
+	vm := &VM{
+		frames:  make([]frame, 0, maxFrames),
+		globals: make(map[string]object.Value),
+		out:     out,
+	}
+	for _, builtin := range object.Builtins(out) {
+		vm.globals[builtin.Name] = builtin
+	}
+
+	return vm
+}
+
+// SetImporter sets the function used to load the modules imported by the
+// program. Without one, import statements fail.
+func (vm *VM) SetImporter(importer object.Importer) {
+	vm.importer = importer
+}
+
+// SetArgs sets the arguments returned by the args builtin.
+func (vm *VM) SetArgs(args []string) {
+	vm.globals["args"] = object.Args(args)
+}
+
+// Globals returns the global variables of the program, including builtins.
+func (vm *VM) Globals() map[string]object.Value {
+	return vm.globals
+}
+
+func (vm *VM) Run(fn *compiler.Function) error {
+	vm.stack = vm.stack[:0]
+	vm.openUpvalues = vm.openUpvalues[:0]
+
+	closure := &Closure{Fn: fn, Globals: vm.globals}
+	vm.frames = append(vm.frames[:0], frame{closure: closure, fn: fn})
+
+	err := vm.run()
+	if err != nil {
+		vm.frames = vm.frames[:0]
+	}
+
+	return err
+}
+
+func (vm *VM) run() error {
+	f := &vm.frames[len(vm.frames)-1]
+	code := f.fn.Chunk.Code
+
+	for {
+		op := compiler.Opcode(code[f.ip])
+		f.ip++
+
+		switch op {
+		case compiler.OpConstant:
+			vm.push(f.fn.Chunk.Constants[vm.readU16(f)])
+		case compiler.OpNull:
+			vm.push(object.Null{})
+		case compiler.OpTrue:
+			vm.push(object.Bool(true))
+		case compiler.OpFalse:
+			vm.push(object.Bool(false))
+
+		case compiler.OpPop:
+			vm.pop()
+		case compiler.OpDup:
+			vm.push(vm.peek(0))
+		case compiler.OpDup2:
+			vm.push(vm.peek(1))
+			vm.push(vm.peek(1))
+
+		case compiler.OpDefineGlobal:
+			name := f.fn.Chunk.Constants[vm.readU16(f)].(object.String)
+			f.closure.Globals[string(name)] = vm.pop()
+		case compiler.OpGetGlobal:
+			name := f.fn.Chunk.Constants[vm.readU16(f)].(object.String)
+			value, ok := f.closure.Globals[string(name)]
+			if !ok {
+				return vm.runtimeError(f, "undefined variable '%s'", name)
+			}
+			vm.push(value)
+		case compiler.OpSetGlobal:
+			name := f.fn.Chunk.Constants[vm.readU16(f)].(object.String)
+			if _, ok := f.closure.Globals[string(name)]; !ok {
+				return vm.runtimeError(f, "undefined variable '%s'", name)
+			}
+			f.closure.Globals[string(name)] = vm.peek(0)
+		case compiler.OpGetLocal:
+			vm.push(vm.stack[f.base+vm.readU8(f)])
+		case compiler.OpSetLocal:
+			vm.stack[f.base+vm.readU8(f)] = vm.peek(0)
+		case compiler.OpGetUpvalue:
+			vm.push(vm.getUpvalue(f.closure.Upvalues[vm.readU8(f)]))
+		case compiler.OpSetUpvalue:
+			vm.setUpvalue(f.closure.Upvalues[vm.readU8(f)], vm.peek(0))
+
+		case compiler.OpInstance:
+			count := vm.readU8(f)
+			fields := make([]string, count)
+			values := make([]object.Value, count)
+			for n := count - 1; n >= 0; n-- {
+				values[n] = vm.pop()
+				fields[n] = string(vm.pop().(object.String))
+			}
+
+			instance, err := object.Instantiate(vm.pop(), fields, values)
+			if err != nil {
+				return vm.runtimeError(f, "%s", err)
+			}
+			vm.push(instance)
+		case compiler.OpGetField:
+			name := f.fn.Chunk.Constants[vm.readU16(f)].(object.String)
+			value, err := object.GetField(vm.pop(), string(name))
+			if err != nil {
+				return vm.runtimeError(f, "%s", err)
+			}
+			vm.push(value)
+		case compiler.OpSetField:
+			name := f.fn.Chunk.Constants[vm.readU16(f)].(object.String)
+			value := vm.pop()
+			if err := object.SetField(vm.pop(), string(name), value); err != nil {
+				return vm.runtimeError(f, "%s", err)
+			}
+			vm.push(value)
+
+		case compiler.OpArray:
+			count := vm.readU16(f)
+			elements := make([]object.Value, count)
+			copy(elements, vm.stack[len(vm.stack)-count:])
+			vm.stack = vm.stack[:len(vm.stack)-count]
+			vm.push(&object.Array{Elements: elements})
+		case compiler.OpMap:
+			count := vm.readU16(f)
+			entries := vm.stack[len(vm.stack)-2*count:]
+			m := object.NewMap()
+			for n := 0; n < len(entries); n += 2 {
+				if err := m.Set(entries[n], entries[n+1]); err != nil {
+					return vm.runtimeError(f, "%s", err)
+				}
+			}
+			vm.stack = vm.stack[:len(vm.stack)-2*count]
+			vm.push(m)
+		case compiler.OpIndex:
+			index, obj := vm.pop(), vm.pop()
+			value, err := object.Index(obj, index)
+			if err != nil {
+				return vm.runtimeError(f, "%s", err)
+			}
+			vm.push(value)
+		case compiler.OpSetIndex:
+			value, index, obj := vm.pop(), vm.pop(), vm.pop()
+			if err := object.SetIndex(obj, index, value); err != nil {
+				return vm.runtimeError(f, "%s", err)
+			}
+			vm.push(value)
+
+		case compiler.OpMul,
+			compiler.OpDiv,
+			compiler.OpMod,
+			compiler.OpAdd,
+			compiler.OpSub,
+			compiler.OpEqual,
+			compiler.OpNotEqual,
+			compiler.OpGreater,
+			compiler.OpGreaterEqual,
+			compiler.OpLess,
+			compiler.OpLessEqual,
+			compiler.OpBitAnd,
+			compiler.OpBitOr,
+			compiler.OpBitXor,
+			compiler.OpRightShift,
+			compiler.OpLeftShift:
+			right, left := vm.pop(), vm.pop()
+			value, err := object.Binary(operators[op], left, right)
+			if err != nil {
+				return vm.runtimeError(f, "%s", err)
+			}
+			vm.push(value)
+
+		case compiler.OpNeg, compiler.OpPos, compiler.OpNot, compiler.OpBitNot:
+			value, err := object.Unary(operators[op], vm.pop())
+			if err != nil {
+				return vm.runtimeError(f, "%s", err)
+			}
+			vm.push(value)
+
+		case compiler.OpJump:
+			f.ip = vm.readU32(f)
+		case compiler.OpJumpIfFalse:
+			target := vm.readU32(f)
+			if !object.Truthy(vm.pop()) {
+				f.ip = target
+			}
+		case compiler.OpJumpIfTrue:
+			target := vm.readU32(f)
+			if object.Truthy(vm.pop()) {
+				f.ip = target
+			}
+
+		case compiler.OpCall:
+			argc := vm.readU8(f)
+			if err := vm.call(f, argc); err != nil {
+				return err
+			}
+			f = &vm.frames[len(vm.frames)-1]
+			code = f.fn.Chunk.Code
+
+		case compiler.OpReturn:
+			result := vm.pop()
+			vm.closeUpvalues(f.base)
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) == 0 {
+				return nil
+			}
+
+			// Discard the arguments and the callee itself
+			vm.stack = vm.stack[:f.base-1]
+			vm.push(result)
+
+			f = &vm.frames[len(vm.frames)-1]
+			code = f.fn.Chunk.Code
+
+		case compiler.OpClosure:
+			fn := f.fn.Chunk.Constants[vm.readU16(f)].(*compiler.Function)
+			closure := &Closure{
+				Fn:       fn,
+				Upvalues: make([]*Upvalue, fn.Upvalues),
+				Globals:  f.closure.Globals,
+			}
+			for n := range closure.Upvalues {
+				isLocal, index := vm.readU8(f), vm.readU8(f)
+				if isLocal == 1 {
+					closure.Upvalues[n] = vm.captureUpvalue(f.base + index)
+				} else {
+					closure.Upvalues[n] = f.closure.Upvalues[index]
+				}
+			}
+			vm.push(closure)
+		case compiler.OpCloseUpvalues:
+			vm.closeUpvalues(f.base + vm.readU8(f))
+
+		case compiler.OpImport:
+			path := f.fn.Chunk.Constants[vm.readU16(f)].(object.String)
+			if vm.importer == nil {
+				return vm.runtimeError(f, "imports are not supported")
+			}
+
+			// Errors initializing the module are reported by the importer
+			m, err := vm.importer(string(path))
+			if err != nil {
+				return err
+			}
+			vm.push(m)
+
+		default:
+			return vm.runtimeError(f, "unknown opcode %d", op)
+		}
+	}
+}
+
+func (vm *VM) call(f *frame, argc int) error {
+	callee := vm.peek(argc)
+
+	switch callee := callee.(type) {
+	case *Closure:
+		if argc != callee.Fn.Arity {
+			return vm.runtimeError(f, "expected %d arguments but got %d", callee.Fn.Arity, argc)
+		}
+
+		if len(vm.frames) == maxFrames {
+			return vm.runtimeError(f, "stack overflow")
+		}
+
+		vm.frames = append(vm.frames, frame{
+			closure: callee,
+			fn:      callee.Fn,
+			base:    len(vm.stack) - argc,
+		})
+		return nil
+	case *object.Builtin:
+		args := make([]object.Value, argc)
+		copy(args, vm.stack[len(vm.stack)-argc:])
+
+		result, err := callee.Fn(args)
+		if err != nil {
+			return vm.runtimeError(f, "%s", err)
+		}
+
+		vm.stack = vm.stack[:len(vm.stack)-argc-1]
+		vm.push(result)
+		return nil
+	default:
+		return vm.runtimeError(f, "can only call functions")
+	}
+}
+
+func (vm *VM) push(value object.Value) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *VM) pop() object.Value {
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+func (vm *VM) peek(distance int) object.Value {
+	return vm.stack[len(vm.stack)-1-distance]
+}
+
+func (vm *VM) readU8(f *frame) int {
+	operand := int(f.fn.Chunk.Code[f.ip])
+	f.ip++
+	return operand
+}
+
+func (vm *VM) readU16(f *frame) int {
+	operand := f.fn.Chunk.ReadU16(f.ip)
+	f.ip += 2
+	return operand
+}
+
+func (vm *VM) readU32(f *frame) int {
+	operand := f.fn.Chunk.ReadU32(f.ip)
+	f.ip += 4
+	return operand
+}
+
+// runtimeError reports an error in the instruction being executed, pointing
+// at the code it was compiled from.
+func (vm *VM) runtimeError(f *frame, format string, args ...interface{}) error {
+	span := f.fn.Chunk.Spans[f.ip-1]
+	return diag.New(diag.Runtime, span, format, args...)
+}
@@ -0,0 +1,239 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"blorbo/pkg/ast"
+	"blorbo/pkg/compiler"
+	"blorbo/pkg/interp"
+	"blorbo/pkg/module"
+	"blorbo/pkg/object"
+)
+
+// scripts are run on the VM and the interpreter, which must print the same
+// output and fail with the same error.
+var scripts = []struct {
+	name string
+	src  string
+	want string
+}{
+	{
+		name: "arithmetic",
+		src:  `println(7 % 3, -7 / 2, 2.5 * 2, 1 << 3, 5 & 3, 5 | 3, 5 ^ 3, ~0, 0xff, 1_000, 1e3);`,
+		want: "1 -3 5.0 8 1 7 6 -1 255 1000 1000.0\n",
+	},
+	{
+		name: "comparison",
+		src:  `println(1 == 1.0, "x" < "y", null == false, !0, 1 and "a", null or 2);`,
+		want: "true true false false a 2\n",
+	},
+	{
+		name: "strings",
+		src:  `var n = 2; println("a${n + 1}b\t\"${"c" + "d"}\"", "x"[0], len("é"));`,
+		want: "a3b\t\"cd\" x 2\n",
+	},
+	{
+		name: "closures",
+		src: `
+fn counter() {
+    var n = 0;
+    return fn () { n += 1; return n; };
+}
+var c = counter();
+c();
+println(c(), counter()());`,
+		want: "2 1\n",
+	},
+	{
+		name: "structs",
+		src: `
+struct P { x, y }
+var p = P { x: 1 };
+p.y = p.x + 1;
+p.x *= 10;
+println(p.x, p.y);`,
+		want: "10 2\n",
+	},
+	{
+		name: "collections",
+		src: `
+var a = [1, 2, 3,];
+a[0] += 10;
+a[1]++;
+push(a, pop(a) * 2);
+var m = {"one": 1, 2: "two"};
+m["one"] -= 1;
+delete(m, 2);
+println(a, m, len(a), keys(m), has(m, "one"));`,
+		want: "[11, 3, 6] {\"one\": 0} 3 [\"one\"] true\n",
+	},
+	{
+		name: "cycles",
+		src: `
+var a = [];
+push(a, a);
+var m = {"a": a};
+m["m"] = m;
+println(a, m);`,
+		want: "[[...]] {\"a\": [[...]], \"m\": {...}}\n",
+	},
+	{
+		name: "loops",
+		src: `
+var s = "";
+outer: for (var i = 0; i < 3; i++) {
+    var j = 0;
+    while (true) {
+        j++;
+        if (j == 2) continue;
+        if (j > 3) continue outer;
+        if (i == 2) break outer;
+        s += "${i}${j} ";
+    }
+}
+println(s);`,
+		want: "01 03 11 13 \n",
+	},
+	{
+		name: "recursion",
+		src: `
+fn fib(n) {
+    if (n < 2) return n;
+    return fib(n - 1) + fib(n - 2);
+}
+println(fib(15));`,
+		want: "610\n",
+	},
+	{
+		name: "unbraced declarations",
+		src: `
+var y = "global";
+if (true) var y = 1;
+if (false) {} else fn y() {}
+println(y);`,
+		want: "global\n",
+	},
+	{
+		name: "unbraced declaration skipped",
+		src:  `fn g() { if (false) var y = 1; return y; } println(g());`,
+		want: "1:39: error: undefined variable 'y'",
+	},
+	{
+		name: "unbraced declaration in for",
+		src:  `{ for (var i = 0; i < 3; i++) var x = i; var z = "z"; println(z); }`,
+		want: "z\n",
+	},
+	{
+		name: "unbraced declaration in while",
+		src:  `fn g() { var i = 0; while (i < 3) var x = i = i + 1; var after = 42; return after; } println(g());`,
+		want: "42\n",
+	},
+	{
+		name: "module members",
+		src: `
+import "counter.bb";
+counter.inc();
+counter.inc();
+println(counter.count);`,
+		want: "2\n",
+	},
+	{
+		name: "long jumps",
+		src: `
+var n = 0;
+while (n < 2) {
+    n += 1;
+` + strings.Repeat("    n = n + 1; n = n - 1;\n", 10000) + `}
+println(n);`,
+		want: "2\n",
+	},
+	{
+		name: "division by zero",
+		src:  `println("before"); println(1 / 0);`,
+		want: "before\n1:30: error: division by zero",
+	},
+	{
+		name: "index out of range",
+		src:  `var a = [1]; println(a[3]);`,
+		want: "1:23: error: index 3 out of range for length 1",
+	},
+	{
+		name: "missing key",
+		src:  `var m = {}; println(m["x"]);`,
+		want: "1:22: error: key \"x\" not found in map",
+	},
+	{
+		name: "stack overflow",
+		src: `
+fn f() { return f(); }
+f();`,
+		want: "2:18: error: stack overflow",
+	},
+	{
+		name: "error in imported module",
+		src: `
+import "divide.bb";
+println("unreachable");`,
+		want: "testdata/divide.bb:2:13: error: division by zero",
+	},
+}
+
+func runVM(program *ast.Program, importer object.Importer, out *strings.Builder) (map[string]object.Value, error) {
+	fn, err := compiler.New().Compile(program)
+	if err != nil {
+		return nil, err
+	}
+
+	vm := New(out)
+	vm.SetImporter(importer)
+	if err := vm.Run(fn); err != nil {
+		return nil, err
+	}
+	return vm.Globals(), nil
+}
+
+func runInterp(program *ast.Program, importer object.Importer, out *strings.Builder) (map[string]object.Value, error) {
+	i := interp.New(out)
+	i.SetImporter(importer)
+	if err := i.Run(program); err != nil {
+		return nil, err
+	}
+	return i.Globals(), nil
+}
+
+// run runs src with an engine, returning what it printed followed by the
+// error it failed with, if any.
+func run(src string, engine func(*ast.Program, object.Importer, *strings.Builder) (map[string]object.Value, error)) string {
+	loader := module.NewLoader(nil)
+	m, err := loader.LoadSource(src, "testdata")
+	if err != nil {
+		return err.Error()
+	}
+
+	var out strings.Builder
+	exec := func(program *ast.Program, importer object.Importer) (map[string]object.Value, error) {
+		return engine(program, importer, &out)
+	}
+	if err := loader.Run(m, exec); err != nil {
+		out.WriteString(err.Error())
+	}
+
+	return out.String()
+}
+
+func TestEngines(t *testing.T) {
+	for _, script := range scripts {
+		t.Run(script.name, func(t *testing.T) {
+			got := run(script.src, runVM)
+			if got != script.want {
+				t.Errorf("vm printed %q, want %q", got, script.want)
+			}
+
+			got = run(script.src, runInterp)
+			if got != script.want {
+				t.Errorf("interp printed %q, want %q", got, script.want)
+			}
+		})
+	}
+}